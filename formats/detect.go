@@ -0,0 +1,60 @@
+package formats
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AUTO_FORMAT is the input format that works out the format of each file
+// from its contents
+const AUTO_FORMAT = "auto"
+
+// DETECT_SIZE is how much of the start of a file is needed to detect its
+// format
+const DETECT_SIZE = 4096
+
+// tntXread is the xread command, which has to come before the matrix
+var tntXread = regexp.MustCompile(`(?i)(^|[\s;])xread(\s|$)`)
+
+/*
+DetectFormat works out the format of a file from head, the start of it (up
+to DETECT_SIZE bytes).  A FASTA file starts with a '>', a NEXUS file starts
+with #NEXUS, a PHYLIP file starts with the number of taxa and characters,
+and a TNT file has an xread command (there can be others, such as nstates,
+before it).  Blank space at the start is skipped.  It returns "" if the file
+isn't any of them.
+*/
+func DetectFormat(head []byte) string {
+	text := strings.TrimLeft(string(head), "\ufeff \t\r\n")
+	switch {
+	case strings.HasPrefix(text, ">"):
+		return FASTA_FORMAT
+	case strings.HasPrefix(strings.ToUpper(text), "#NEXUS"):
+		return NEXUS_FORMAT
+	case isPhylipHeader(text):
+		return PHYLIP_FORMAT
+	case tntXread.MatchString(text):
+		return TNT_FORMAT
+	}
+	return ""
+}
+
+// isPhylipHeader is true if the first line is the number of taxa and
+// characters (with options, such as I or S, after them)
+func isPhylipHeader(text string) bool {
+	line := text
+	if end := strings.IndexByte(text, '\n'); end >= 0 {
+		line = text[:end]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return false
+	}
+	for _, field := range fields[:2] {
+		if n, err := strconv.Atoi(field); err != nil || n <= 0 {
+			return false
+		}
+	}
+	return true
+}
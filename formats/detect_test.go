@@ -0,0 +1,33 @@
+package formats_test
+
+import (
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+)
+
+func TestDetectFormat(t *testing.T) {
+	for _, test := range []struct {
+		head   string
+		format string
+	}{
+		{">Homo_sapiens\nATAGCTAG\n", formats.FASTA_FORMAT},
+		{"\n\n  >Homo_sapiens\nATAGCTAG\n", formats.FASTA_FORMAT},
+		{"\ufeff>Homo_sapiens\nATAGCTAG\n", formats.FASTA_FORMAT},
+		{"#NEXUS\nBEGIN DATA;\n", formats.NEXUS_FORMAT},
+		{"#nexus\nbegin data;\n", formats.NEXUS_FORMAT},
+		{" 2 8\nHomo_sapi ATAGCTAG\n", formats.PHYLIP_FORMAT},
+		{"2 8 I\nHomo_sapi ATAG\n", formats.PHYLIP_FORMAT},
+		{"nstates DNA;\nxread\n'title'\n8 2\n", formats.TNT_FORMAT},
+		{"xread 8 2\nHomo_sapiens ATAGCTAG\n;\n", formats.TNT_FORMAT},
+		{"mxram 100;nstates 32;XREAD\n", formats.TNT_FORMAT},
+		{"", ""},
+		{"species,voucher\nHomo sapiens,USNM123\n", ""},
+		{"2 eight\nHomo_sapi ATAGCTAG\n", ""},
+		{"nstates DNA;\nxreadings\n", ""},
+	} {
+		if format := formats.DetectFormat([]byte(test.head)); format != test.format {
+			t.Errorf("Expected %q to be detected as '%s', got '%s'", test.head, test.format, format)
+		}
+	}
+}
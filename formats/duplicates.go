@@ -0,0 +1,252 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// DuplicatePolicy is what the matrix does when it is given more than one
+// sequence for the same gene and species
+type DuplicatePolicy int
+
+const (
+	// DUPLICATE_ERROR keeps the first, and makes writing the matrix fail
+	DUPLICATE_ERROR DuplicatePolicy = iota
+	// DUPLICATE_LONGEST keeps the longest sequence
+	DUPLICATE_LONGEST
+	// DUPLICATE_COMPLETE keeps the sequence with the fewest gaps and
+	// missing characters
+	DUPLICATE_COMPLETE
+	// DUPLICATE_FIRST keeps the first sequence added
+	DUPLICATE_FIRST
+	// DUPLICATE_CONSENSUS merges the sequences, with the differences as
+	// polymorphisms, eg [AG]
+	DUPLICATE_CONSENSUS
+)
+
+// DuplicatePolicies are the names of the policies, as given on the command
+// line
+var DuplicatePolicies = map[string]DuplicatePolicy{
+	"error":     DUPLICATE_ERROR,
+	"longest":   DUPLICATE_LONGEST,
+	"complete":  DUPLICATE_COMPLETE,
+	"first":     DUPLICATE_FIRST,
+	"consensus": DUPLICATE_CONSENSUS,
+}
+
+// DuplicatePolicyNames returns the names of the policies, sorted
+func DuplicatePolicyNames() []string {
+	names := make([]string, 0, len(DuplicatePolicies))
+	for name, _ := range DuplicatePolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseDuplicatePolicy returns the policy with that name
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	if policy, ok := DuplicatePolicies[name]; ok {
+		return policy, nil
+	}
+	return DUPLICATE_ERROR, fmt.Errorf("Unknown duplicate policy '%s'; must be one of %s", name, strings.Join(DuplicatePolicyNames(), ", "))
+}
+
+// Collision is a gene and species that was given more than one sequence
+type Collision struct {
+	Gene    string
+	Species string
+	// Names of the sequences, in the order they were added
+	Names []string
+	// Kept is the name of the sequence that was kept, or blank if they
+	// were merged
+	Kept string
+	// Err is set if the sequences couldn't be merged
+	Err error
+}
+
+func (c Collision) String() string {
+	var outcome string
+	switch {
+	case c.Err != nil:
+		outcome = c.Err.Error()
+	case c.Kept == "":
+		outcome = "merged into a consensus"
+	default:
+		outcome = fmt.Sprintf("kept %s", c.Kept)
+	}
+	return fmt.Sprintf("%s, %s: %d sequences (%s); %s", c.Gene, c.Species, len(c.Names), strings.Join(c.Names, ", "), outcome)
+}
+
+/*
+addDuplicate resolves a second (or later) sequence for the same gene and
+species with the DuplicatePolicy, and records the collision.  It returns
+the sequence to keep.
+*/
+func (t *Matrix) addDuplicate(existing, seq sequence.Sequence) sequence.Sequence {
+	key := seq.Gene + "\x00" + seq.Species
+	if t.collisions == nil {
+		t.collisions = make(map[string]int)
+	}
+	i, ok := t.collisions[key]
+	if !ok {
+		i = len(t.Collisions)
+		t.collisions[key] = i
+		t.Collisions = append(t.Collisions, Collision{
+			Gene:    seq.Gene,
+			Species: seq.Species,
+			Names:   []string{existing.Name},
+			Kept:    existing.Name,
+		})
+	}
+	collision := &t.Collisions[i]
+	collision.Names = append(collision.Names, seq.Name)
+
+	keep := existing
+	switch t.Duplicates {
+	case DUPLICATE_LONGEST:
+		if seq.Length > existing.Length {
+			keep = seq
+		}
+	case DUPLICATE_COMPLETE:
+		if completeness(seq.Seq) > completeness(existing.Seq) {
+			keep = seq
+		}
+	case DUPLICATE_CONSENSUS:
+		if collision.Err != nil {
+			break
+		}
+		merged, err := consensus(existing.Seq, seq.Seq)
+		if err != nil {
+			collision.Err = err
+			break
+		}
+		keep = sequence.NewSequence(existing.Name, merged)
+		keep.Species, keep.Gene, keep.Fields = existing.Species, existing.Gene, existing.Fields
+		collision.Kept = ""
+		return keep
+	}
+	collision.Kept = keep.Name
+	return keep
+}
+
+// DuplicatesError returns an error listing the collisions, if the policy
+// is DUPLICATE_ERROR or some of them couldn't be merged
+func (t *Matrix) DuplicatesError() error {
+	details := []string{}
+	for _, collision := range t.Collisions {
+		if t.Duplicates == DUPLICATE_ERROR || collision.Err != nil {
+			details = append(details, "\t"+collision.String())
+		}
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	return sequence.InvalidSequence{
+		Message: "More than one sequence for the same species and gene",
+		Details: fmt.Sprintf("Choose a duplicate policy (%s) for:\n%s", strings.Join(DuplicatePolicyNames(), ", "), strings.Join(details, "\n")),
+		Errno:   sequence.DUPLICATE_SPECIES,
+	}
+}
+
+// missing is true if the character is a gap, or missing data
+func missing(char sequence.SequenceData) bool {
+	return len(char) == 1 && (char[0] == '-' || char[0] == '?')
+}
+
+// completeness is the number of characters which aren't gaps or missing
+func completeness(seq sequence.SequenceData) (complete int) {
+	nucleotide := isNucleotide(seq)
+	for _, char := range seq.Characters() {
+		if missing(char) || (nucleotide && len(char) == 1 && (char[0] == 'N' || char[0] == 'n')) {
+			continue
+		}
+		complete++
+	}
+	return
+}
+
+// isNucleotide is true if all the letters are DNA bases or IUPAC codes
+func isNucleotide(seq sequence.SequenceData) bool {
+	for _, c := range seq {
+		if len(sequence.IUPACBases(c)) == 0 && !strings.ContainsRune("-?[]", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// states returns the states of a character; the ambiguity codes are
+// expanded into their bases when the sequence is DNA
+func states(char sequence.SequenceData, nucleotide bool) []byte {
+	char = bytes.ToUpper(char)
+	if char[0] == '[' {
+		char = bytes.Trim(char, "[]")
+	}
+	if !nucleotide {
+		return char
+	}
+	expanded := []byte{}
+	for _, c := range char {
+		if bases := sequence.IUPACBases(c); len(bases) > 0 {
+			expanded = append(expanded, bases...)
+		} else {
+			expanded = append(expanded, c)
+		}
+	}
+	return expanded
+}
+
+/*
+consensus merges two sequences of the same length; characters that differ
+become a polymorphism (eg A and G make [AG], and R and C make [ACG]).  Gaps
+and missing characters are filled in from the other sequence, and an N is
+treated as missing for DNA.
+*/
+func consensus(a, b sequence.SequenceData) (sequence.SequenceData, error) {
+	charsA, charsB := a.Characters(), b.Characters()
+	if len(charsA) != len(charsB) {
+		return nil, fmt.Errorf("can't build a consensus of sequences with different lengths (%d and %d)", len(charsA), len(charsB))
+	}
+	nucleotide := isNucleotide(a) && isNucleotide(b)
+	unknown := func(char sequence.SequenceData) bool {
+		return missing(char) || (nucleotide && len(char) == 1 && (char[0] == 'N' || char[0] == 'n'))
+	}
+
+	merged := make(sequence.SequenceData, 0, len(a))
+	for i, charA := range charsA {
+		charB := charsB[i]
+		switch {
+		case unknown(charB):
+			merged = append(merged, charA...)
+			continue
+		case unknown(charA):
+			merged = append(merged, charB...)
+			continue
+		case bytes.Equal(charA, charB):
+			merged = append(merged, charA...)
+			continue
+		}
+		set := make(map[byte]bool)
+		for _, c := range append(states(charA, nucleotide), states(charB, nucleotide)...) {
+			set[c] = true
+		}
+		union := make([]string, 0, len(set))
+		for c, _ := range set {
+			union = append(union, string(c))
+		}
+		sort.Strings(union)
+		if len(union) == 1 {
+			merged = append(merged, union[0]...)
+			continue
+		}
+		merged = append(merged, '[')
+		merged = append(merged, strings.Join(union, "")...)
+		merged = append(merged, ']')
+	}
+	return merged, nil
+}
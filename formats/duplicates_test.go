@@ -0,0 +1,72 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// individuals are two sequences of the same species and gene
+func individuals(a, b string) []sequence.Sequence {
+	seqs := []sequence.Sequence{}
+	for i, data := range []string{a, b} {
+		seq := sequence.NewSequence([]string{"USNM123", "USNM124"}[i], []byte(data))
+		seq.Species = "Homo sapiens"
+		seq.Gene = "ATP8"
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func TestDuplicateErrorReportsCollision(t *testing.T) {
+	tnt := &formats.TNT{}
+	tnt.AddSequence(individuals("ATAGCTAG", "ATAGCTAA")...)
+
+	if len(tnt.Collisions) != 1 {
+		t.Fatalf("Expected one collision, got %v", tnt.Collisions)
+	}
+	err := tnt.WriteSequences(&bytes.Buffer{})
+	if err == nil {
+		t.Fatalf("Expected an error, got <nil>")
+	}
+	if err.(sequence.InvalidSequence).Errno != sequence.DUPLICATE_SPECIES {
+		t.Errorf("Expected a DUPLICATE_SPECIES error, got %v", err)
+	}
+}
+
+func TestDuplicatePolicies(t *testing.T) {
+	cases := []struct {
+		policy   formats.DuplicatePolicy
+		a, b     string
+		expected string
+	}{
+		{formats.DUPLICATE_FIRST, "ATAGCTAG", "ATAGCTAA", "ATAGCTAG"},
+		{formats.DUPLICATE_LONGEST, "ATAG", "ATAGCTAA", "ATAGCTAA"},
+		{formats.DUPLICATE_COMPLETE, "AT--CTAN", "ATA?CTAG", "ATA?CTAG"},
+		{formats.DUPLICATE_CONSENSUS, "AT--CTAG", "ATA?CRNA", "ATA-C[AGT]A[AG]"},
+	}
+	for _, c := range cases {
+		tnt := &formats.TNT{}
+		tnt.Duplicates = c.policy
+		tnt.AddSequence(individuals(c.a, c.b)...)
+
+		got := tnt.Sequences["ATP8"]["Homo sapiens"]
+		if string(got.Seq) != c.expected {
+			t.Errorf("Expected policy %d to keep '%s', got '%s'", c.policy, c.expected, got.Seq)
+		}
+		if len(tnt.Collisions) != 1 {
+			t.Errorf("Expected the collision to be reported, got %v", tnt.Collisions)
+		}
+	}
+}
+
+func TestConsensusOfDifferentLengths(t *testing.T) {
+	tnt := &formats.TNT{Matrix: formats.Matrix{Duplicates: formats.DUPLICATE_CONSENSUS}}
+	tnt.AddSequence(individuals("ATAG", "ATAGCTAA")...)
+
+	if err := tnt.WriteSequences(&bytes.Buffer{}); err == nil {
+		t.Errorf("Expected an error for sequences that can't be merged, got <nil>")
+	}
+}
@@ -1,27 +1,59 @@
 package formats
 
 import (
-	"fmt"
+	"bytes"
 	"io"
 	"text/template"
 
+	"github.com/yarbelk/refasta/header"
 	"github.com/yarbelk/refasta/sequence"
 )
 
-const fastaTemplateString = `{{ range $i, $seq := . -}}
+const fastaTemplateString = `{{ range $i, $seq := .Sequences -}}
 >{{- $seq.SafeName }}
-{{ printf "%s" .Seq }}
+{{ wrap $seq.Seq $.LineWidth }}
 {{ end }}
 `
 
 const FASTA_FORMAT = "fasta"
 
-var fastaTemplate = template.Must(template.New("fasta").Parse(fastaTemplateString))
+var fastaTemplate = template.Must(template.New("fasta").Funcs(template.FuncMap{
+	"wrap": wrapSequence,
+}).Parse(fastaTemplateString))
 
 // FastaWriter writes a seriese of sequences to a fasta file
 type Fasta struct {
 	Sequences     []sequence.Sequence
 	SpeciesFromID bool
+	// Schema, if set, reads the species, gene and other fields from the
+	// sequence IDs
+	Schema *header.Schema
+	// LineWidth wraps the sequence data onto lines of at most this many
+	// characters; if it is 0, each sequence is on one line
+	LineWidth int
+}
+
+/*
+wrapSequence splits the sequence data up into lines of at most width
+characters.  A polymorphism (`[AG]`) is never split over two lines; so a
+line is only longer than the width if a polymorphism is wider than that
+all by itself.
+*/
+func wrapSequence(seq sequence.SequenceData, width int) string {
+	if width <= 0 || len(seq) <= width {
+		return string(seq)
+	}
+	buf := bytes.Buffer{}
+	line := 0
+	for _, c := range seq.Characters() {
+		if line > 0 && line+len(c) > width {
+			buf.WriteByte('\n')
+			line = 0
+		}
+		buf.Write(c)
+		line = line + len(c)
+	}
+	return buf.String()
 }
 
 // AddSequence (or many) to the internal list of sequences of the writer
@@ -31,7 +63,7 @@ func (f *Fasta) AddSequence(seqs ...sequence.Sequence) {
 
 // WriteSequences writes the stored sequences to the stored file pointer
 func (f *Fasta) WriteSequences(writer io.Writer) error {
-	return fastaTemplate.Execute(writer, f.Sequences)
+	return fastaTemplate.Execute(writer, f)
 }
 
 // Parse will read a file, and append all new Sequences to the store
@@ -44,20 +76,27 @@ func (f *Fasta) Parse(input io.Reader, geneName ...string) error {
 	fastaScanner := NewFastaScanner(input)
 	var newSequence sequence.Sequence = sequence.NewSequence("", []byte{})
 	var lastToken Token = UNSTARTED
+	// formatError is at the current position, in the current sequence
+	formatError := func(message, details string) sequence.FormatError {
+		line, column := fastaScanner.Position()
+		return sequence.FormatError{
+			Message:    message,
+			Details:    details,
+			Errno:      sequence.BAD_FORMAT,
+			Line:       line,
+			Column:     column,
+			SequenceID: newSequence.Name,
+		}
+	}
 	for {
 		token, lit, alpha, length := fastaScanner.Scan()
 
 		switch token {
 		case SEQUENCE_ID:
 			if lastToken == SEQUENCE_ID {
-				return sequence.FormatError{
-					Message: "Badly formated FASTA file",
-					Details: "Two sequence id ('>....', without any data in between",
-					Errno:   sequence.BAD_FORMAT,
-				}
+				return formatError("Badly formated FASTA file", "Two sequence id ('>....', without any data in between")
 			}
 			newSequence = sequence.Sequence{Name: string(lit), Gene: gene}
-			(&newSequence).SetAlphabet(alpha)
 			if f.SpeciesFromID {
 				newSequence.Species = string(lit)
 			}
@@ -65,20 +104,28 @@ func (f *Fasta) Parse(input io.Reader, geneName ...string) error {
 			continue
 		case SEQUENCE_DATA:
 			if lastToken != SEQUENCE_ID {
-				return sequence.FormatError{
-					Message: "Badly formated FASTA file",
-					Details: "Sequence data did not have a Sequence ID",
-					Errno:   sequence.BAD_FORMAT,
-				}
+				return formatError("Badly formated FASTA file", "Sequence data did not have a Sequence ID")
 			}
 			newSequence.Seq = lit
 			newSequence.Length = length
+			(&newSequence).SetAlphabet(alpha)
+			if f.Schema != nil {
+				if err := f.Schema.Apply(&newSequence); err != nil {
+					if schemaErr, ok := err.(sequence.FormatError); ok {
+						schemaErr.Line, schemaErr.SequenceID = fastaScanner.headerLine, newSequence.Name
+						return schemaErr
+					}
+					return err
+				}
+			}
 			lastToken = SEQUENCE_DATA
 			f.Sequences = append(f.Sequences, newSequence)
 		case EOF:
 			return nil
 		case INVALID:
-			return fmt.Errorf("Invalid characters in the stream")
+			err := fastaScanner.Err().(sequence.FormatError)
+			err.SequenceID = newSequence.Name
+			return err
 		}
 	}
 }
@@ -1,10 +1,11 @@
 package formats
 
 import (
-	"bufio"
+	"fmt"
 	"io"
 
 	"github.com/yarbelk/refasta/scanner"
+	"github.com/yarbelk/refasta/sequence"
 )
 
 const (
@@ -19,18 +20,44 @@ const (
 type Token int
 
 type FastaScanner struct {
-	reader   *bufio.Reader
+	reader   *scanner.Reader
 	alphabet map[rune]bool
+	err      error
+	// headerLine is the line of the last sequence ID
+	headerLine int
 }
 
 func NewFastaScanner(reader io.Reader) FastaScanner {
-	return FastaScanner{reader: bufio.NewReader(reader)}
+	return FastaScanner{reader: scanner.NewReader(reader)}
+}
+
+// Position is the line and column of the last character scanned
+func (f *FastaScanner) Position() (line, column int) {
+	return f.reader.Position()
+}
+
+// Err is why the last token was INVALID; a sequence.FormatError with the
+// line and column of the bad character
+func (f *FastaScanner) Err() error {
+	return f.err
+}
+
+// invalid records the error for Err, at the current position
+func (f *FastaScanner) invalid(message string) (Token, []byte, map[rune]bool, int) {
+	line, column := f.reader.Position()
+	f.err = sequence.FormatError{
+		Message: message,
+		Errno:   sequence.BAD_FORMAT,
+		Line:    line,
+		Column:  column,
+	}
+	return INVALID, []byte{}, nil, 0
 }
 
 // Scan will return the next token, byte literal of the
 // non-interleaved data, and the length of the token's
 // value
-func (f FastaScanner) Scan() (Token, []byte, map[rune]bool, int) {
+func (f *FastaScanner) Scan() (Token, []byte, map[rune]bool, int) {
 	ch, size, err := f.reader.ReadRune()
 	if err != nil {
 		return EOF, []byte{}, nil, 0
@@ -40,33 +67,31 @@ func (f FastaScanner) Scan() (Token, []byte, map[rune]bool, int) {
 		lit, length := f.scanSequenceId()
 		return SEQUENCE_ID, lit, nil, length
 	case size > 1:
-		return INVALID, []byte{}, nil, 0
-	case scanner.IsSequenceData(ch):
+		return f.invalid(fmt.Sprintf("unexpected '%c'", ch))
+	case scanner.IsSequenceData(ch), ch == '[':
 		f.reader.UnreadRune()
 		lit, length, alpha, err := scanner.ScanSequenceData(f.reader)
 		if err != nil && err != io.EOF {
-			return INVALID, []byte{}, nil, 0
+			return f.invalid(err.Error())
 		}
 		return SEQUENCE_DATA, lit, alpha, length
 	case scanner.IsWhitespace(ch):
-		f.reader.UnreadByte()
+		f.reader.UnreadRune()
 		lit, length, err := scanner.ScanWhitespace(f.reader)
-		if err != io.EOF {
-			return INVALID, []byte{}, nil, 0
+		if err != nil && err != io.EOF {
+			return f.invalid(err.Error())
 		}
 		return WHITESPACE, lit, nil, length
 	default:
-		return EOF, []byte{}, nil, 0
+		return f.invalid(fmt.Sprintf("unexpected '%c'", ch))
 	}
 }
 
 // scanSequenceId from the fasta file.  This is the part following
-// a '>'.  I cheat by using the read string til \n,
+// a '>', up to the end of the line.
 // it returns the length of the id string
-func (f FastaScanner) scanSequenceId() ([]byte, int) {
-	line, err := f.reader.ReadSlice('\n')
-	if err != nil {
-		return line, 0
-	}
-	return line[:len(line)-1], len(line) - 1
+func (f *FastaScanner) scanSequenceId() ([]byte, int) {
+	f.headerLine, _ = f.reader.Position()
+	line, _ := f.reader.ReadLine()
+	return line, len(line)
 }
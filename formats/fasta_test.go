@@ -42,6 +42,38 @@ func TestWithSequenceCanWriteOneFasta(t *testing.T) {
 	}
 }
 
+func TestWriteFastaWrapsLines(t *testing.T) {
+	sequence := sequence.NewSequence("Homo sapiens", []byte("ATAG[AG]CTAGCT[AGT]"))
+	expected := ">Homo_sapiens\nATAG\n[AG]\nCTAG\nCT\n[AGT]\n\n"
+
+	output := &bytes.Buffer{}
+
+	fasta := formats.Fasta{LineWidth: 4}
+	fasta.AddSequence(sequence)
+	if err := fasta.WriteSequences(output); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got := output.String()
+	if got != expected {
+		t.Errorf("Did not get expected outputs for wrapped fasta:\n\n\tGot:\n\n%q\n\n\tExpected:\n\n%q", got, expected)
+	}
+}
+
+func TestWrappedFastaReadsBack(t *testing.T) {
+	output := &bytes.Buffer{}
+	fasta := formats.Fasta{LineWidth: 60}
+	fasta.AddSequence(sequence.NewSequence(testSequenceName, testSequence))
+	fasta.WriteSequences(output)
+
+	fastaReader := formats.Fasta{}
+	if err := fastaReader.Parse(output, testGeneName); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := string(fastaReader.Sequences[0].Seq); got != string(testSequence) {
+		t.Errorf("Expected the wrapped sequence to read back as:\n%s\ngot:\n%s", testSequence, got)
+	}
+}
+
 func TestCanWriteTwoFasta(t *testing.T) {
 	sequence1 := sequence.NewSequence(testSequenceName, testSequence)
 	sequence2 := sequence.NewSequence("Sequence Two", testSequence)
@@ -154,3 +186,32 @@ func TestTwoIDSBackToBackReturnsError(t *testing.T) {
 			err.(sequence.FormatError).Errno)
 	}
 }
+
+func TestBadCharacterErrorHasPosition(t *testing.T) {
+	input := bytes.NewBufferString(">Homo_sapiens\nATAGCTAG\nATA*CT\n")
+	fastaReader := formats.Fasta{}
+
+	err := fastaReader.Parse(input, testGeneName)
+	if err == nil {
+		t.Fatalf("Expected an error, error was <nil>")
+	}
+	formatErr := err.(sequence.FormatError)
+	formatErr.File = "genes/co1.fas"
+
+	expected := "genes/co1.fas:3:4: unexpected '*' in sequence >Homo_sapiens"
+	if formatErr.Error() != expected {
+		t.Errorf("Expected the error '%s', was '%s'", expected, formatErr.Error())
+	}
+}
+
+func TestParseKeepsAlphabetForType(t *testing.T) {
+	inputString := fmt.Sprintf(fastaFormat, testSequenceName, testSequence) + "\n"
+	input := bytes.NewBuffer([]byte(inputString))
+	fastaReader := formats.Fasta{}
+
+	fastaReader.Parse(input, testGeneName)
+
+	if seqType := fastaReader.Sequences[0].Type(); seqType != sequence.DNA_TYPE {
+		t.Errorf("Expected the parsed sequence to be DNA (%d), was '%d'", sequence.DNA_TYPE, seqType)
+	}
+}
@@ -0,0 +1,161 @@
+package formats
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+/*
+Filter is the completeness a matrix needs to have; the taxa and genes which
+don't are removed.  A zero value is no limit.
+*/
+type Filter struct {
+	// MinGenes is the fewest genes a taxon can have data for
+	MinGenes int
+	// MinCompleteness is the smallest fraction (0 to 1) of the concatenated
+	// matrix a taxon can have data for; gaps and missing data don't count
+	MinCompleteness float64
+	// MinTaxa is the fewest taxa a gene can have data for
+	MinTaxa int
+}
+
+// Removal is a taxon or a gene that was filtered out, and why
+type Removal struct {
+	Species string
+	Gene    string
+	Reason  string
+}
+
+func (r Removal) String() string {
+	if r.Gene != "" {
+		return fmt.Sprintf("removed gene %s: %s", r.Gene, r.Reason)
+	}
+	return fmt.Sprintf("removed taxon %s: %s", r.Species, r.Reason)
+}
+
+// present returns the number of characters each species has data for in
+// each gene, keyed by gene and then species
+func (t *Matrix) present() map[string]map[string]int {
+	present := make(map[string]map[string]int, len(t.Sequences))
+	for gene, seqs := range t.Sequences {
+		nucleotide := t.geneType(gene) == sequence.DNA_TYPE
+		present[gene] = make(map[string]int, len(seqs))
+		for name, seq := range seqs {
+			for _, char := range seq.Seq.Characters() {
+				if !missingChar(char, nucleotide) {
+					present[gene][name]++
+				}
+			}
+		}
+	}
+	return present
+}
+
+// removeGene takes the gene out of the matrix
+func (t *Matrix) removeGene(gene string) {
+	delete(t.Sequences, gene)
+}
+
+// removeSpecies takes the species out of all the genes
+func (t *Matrix) removeSpecies(species string) {
+	for gene, _ := range t.Sequences {
+		delete(t.Sequences[gene], species)
+	}
+	i := sort.SearchStrings(t.speciesNames, species)
+	if i < len(t.speciesNames) && t.speciesNames[i] == species {
+		t.speciesNames = append(t.speciesNames[:i], t.speciesNames[i+1:]...)
+	}
+}
+
+/*
+Filter removes the genes with too few taxa, and the taxa with too few genes
+or too little data, and then generates the MetaData again.  A taxon with no
+data in the genes that are left is always removed.  Removing taxa can leave
+a gene with too few taxa (and the other way around), so this is repeated
+until nothing else is removed.  Everything removed is returned, in
+the order it was removed.
+*/
+func (t *Matrix) Filter(filter Filter) ([]Removal, error) {
+	removed := []Removal{}
+	if _, err := t.GenerateMetaData(); err != nil {
+		return nil, err
+	}
+	for {
+		before := len(removed)
+		present := t.present()
+
+		for _, gmd := range t.MetaData {
+			taxa := 0
+			for _, count := range present[gmd.Gene] {
+				if count > 0 {
+					taxa++
+				}
+			}
+			if taxa < filter.MinTaxa {
+				removed = append(removed, Removal{
+					Gene:   gmd.Gene,
+					Reason: fmt.Sprintf("%d taxa have data for it; the minimum is %d", taxa, filter.MinTaxa),
+				})
+				t.removeGene(gmd.Gene)
+			}
+		}
+
+		total := 0
+		for _, gmd := range t.MetaData {
+			if _, ok := t.Sequences[gmd.Gene]; ok {
+				total += gmd.Length
+			}
+		}
+		for _, name := range append([]string{}, t.speciesNames...) {
+			genes, characters := 0, 0
+			for gene, _ := range t.Sequences {
+				if present[gene][name] > 0 {
+					genes++
+					characters += present[gene][name]
+				}
+			}
+			completeness := 0.0
+			if total > 0 {
+				completeness = float64(characters) / float64(total)
+			}
+			switch {
+			case genes == 0:
+				// the genes it had data for have all been removed
+				removed = append(removed, Removal{
+					Species: name,
+					Reason:  "it has no data in the genes that are left",
+				})
+			case genes < filter.MinGenes:
+				removed = append(removed, Removal{
+					Species: name,
+					Reason:  fmt.Sprintf("it has data for %d genes; the minimum is %d", genes, filter.MinGenes),
+				})
+			case completeness < filter.MinCompleteness:
+				removed = append(removed, Removal{
+					Species: name,
+					Reason: fmt.Sprintf("it has data for %.1f%% of the characters; the minimum is %.1f%%",
+						100*completeness, 100*filter.MinCompleteness),
+				})
+			default:
+				continue
+			}
+			t.removeSpecies(name)
+		}
+		for _, gmd := range t.MetaData {
+			if seqs, ok := t.Sequences[gmd.Gene]; ok && len(seqs) == 0 {
+				removed = append(removed, Removal{Gene: gmd.Gene, Reason: "all of its taxa were removed"})
+				t.removeGene(gmd.Gene)
+			}
+		}
+
+		t.MetaData = nil
+		if _, err := t.GenerateMetaData(); err != nil {
+			return removed, err
+		}
+		if len(removed) == before {
+			return removed, nil
+		}
+	}
+}
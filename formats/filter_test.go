@@ -0,0 +1,80 @@
+package formats_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+)
+
+func filterMatrix() *formats.Matrix {
+	matrix := &formats.Matrix{}
+	matrix.AddSequence(
+		validateSequence("A a", "ATP8", "ATAG"),
+		validateSequence("B b", "ATP8", "ATAG"),
+		validateSequence("C c", "ATP8", "A---"),
+		validateSequence("A a", "ATP6", "ATAGCT"),
+		validateSequence("B b", "ATP6", "ATAGCT"),
+		validateSequence("A a", "COX1", "MKLV"),
+	)
+	return matrix
+}
+
+func TestFilterMinTaxaAndGenes(t *testing.T) {
+	matrix := filterMatrix()
+	removed, err := matrix.Filter(formats.Filter{MinTaxa: 2, MinGenes: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []formats.Removal{
+		{Gene: "COX1", Reason: "1 taxa have data for it; the minimum is 2"},
+		{Species: "C c", Reason: "it has data for 1 genes; the minimum is 2"},
+	}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected the removals %v, got %v", expected, removed)
+	}
+	if len(matrix.MetaData) != 2 || matrix.MetaData[0].Gene != "ATP6" || matrix.MetaData[1].Gene != "ATP8" {
+		t.Errorf("Expected the meta data to be regenerated for ATP6 and ATP8, got %v", matrix.MetaData)
+	}
+	if _, ok := matrix.Sequences["ATP8"]["C c"]; ok {
+		t.Errorf("Expected 'C c' to be removed from ATP8")
+	}
+}
+
+func TestFilterMinCompletenessCascades(t *testing.T) {
+	matrix := filterMatrix()
+	// COX1 only has one taxon, and then C c has 1 of the 10 characters left
+	removed, err := matrix.Filter(formats.Filter{MinCompleteness: 0.5, MinTaxa: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(removed) != 2 || removed[0].Gene != "COX1" || removed[1].Species != "C c" {
+		t.Errorf("Expected COX1 and then 'C c' to be removed, got %v", removed)
+	}
+}
+
+func TestFilterRemovesTaxaLeftWithNoData(t *testing.T) {
+	matrix := &formats.Matrix{}
+	matrix.AddSequence(
+		validateSequence("A a", "g1", "ATAG"),
+		validateSequence("B b", "g1", "ATAG"),
+		validateSequence("C c", "g1", "ATAG"),
+		validateSequence("A a", "g2", "ATAGCT"),
+		validateSequence("D d", "g2", "ATAGCT"),
+		validateSequence("E e", "g3", "ATAG"),
+	)
+	removed, err := matrix.Filter(formats.Filter{MinTaxa: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []formats.Removal{
+		{Gene: "g3", Reason: "1 taxa have data for it; the minimum is 2"},
+		{Species: "E e", Reason: "it has no data in the genes that are left"},
+	}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected the removals %v, got %v", expected, removed)
+	}
+	if taxa := matrix.Taxa(); !reflect.DeepEqual(taxa, []string{"A a", "B b", "C c", "D d"}) {
+		t.Errorf("Expected 'E e' to be taken out of the taxa, got %v", taxa)
+	}
+}
@@ -0,0 +1,412 @@
+package formats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// Matrix is the concatenation of many genes into a single species x
+// characters matrix.  It holds the shared logic for the formats which
+// write out all the genes as one block of data per species (TNT, Nexus)
+// TODO move Sequences access to an interface; this way all formats
+// can use the same interface, and I can change the data structure
+// to be a slice, with a lookup map (`map[string]map[string]int`,
+// where the int is the index.)  This makes building aggregate data
+// much more understandable (no nexted loops).  It shouldn't impact
+// performance.
+type Matrix struct {
+	Sequences         map[string]map[string]sequence.Sequence
+	MetaData          sequence.GMDSlice
+	speciesNames      []string
+	Outgroups         []string
+	dirtyData         bool
+	maxSequenceLength int
+	blankSeq          sequence.SequenceData
+	// missing is what absent genes are filled in with; '-' if it isn't set
+	missing byte
+
+	// Duplicates is what to do with more than one sequence for the same
+	// gene and species; every time it happens is added to Collisions
+	Duplicates DuplicatePolicy
+	Collisions []Collision
+	collisions map[string]int
+}
+
+type taxonData struct {
+	SpeciesName string
+	Sequence    sequence.SequenceData
+}
+
+/*
+Construct a species using a GMDSlice to order the gene sequences.
+If there are defined outgroups, then sort them to the front of the
+printable list
+*/
+func (t *Matrix) PrintableTaxa() ([]taxonData, error) {
+	if t.MetaData == nil {
+		if _, err := t.GenerateMetaData(); err != nil {
+			return nil, err
+		}
+	}
+	var allSpecies []taxonData = make([]taxonData, 0, len(t.speciesNames))
+	t.sortByOutgroup()
+
+	for _, n := range t.speciesNames {
+		combinedSequences := make([]byte, 0, t.getTotalLength())
+		for _, gmd := range t.MetaData {
+			combinedSequences = append(combinedSequences, t.Sequences[gmd.Gene][n].Seq...)
+		}
+		allSpecies = append(allSpecies, taxonData{
+			SpeciesName: sequence.Safe(n),
+			Sequence:    combinedSequences,
+		})
+	}
+	return allSpecies, nil
+}
+
+/*
+Taxa returns the species names in the order they are written out; starting
+with the outgroups (in the order they were given), and then the rest in
+alphabetical order.
+*/
+func (t *Matrix) Taxa() []string {
+	if len(t.Outgroups) == 0 {
+		return append([]string{}, t.speciesNames...)
+	}
+	sorted := make([]string, 0, len(t.speciesNames))
+	used := make(map[string]bool, len(t.Outgroups))
+	for _, og := range t.Outgroups {
+		safeOG := sequence.Safe(og)
+		for _, n := range t.speciesNames {
+			if safeOG == sequence.Safe(n) && !used[n] {
+				sorted = append(sorted, n)
+				used[n] = true
+				break
+			}
+		}
+	}
+	for _, n := range t.speciesNames {
+		if !used[n] {
+			sorted = append(sorted, n)
+		}
+	}
+	return sorted
+}
+
+/*
+sortByOutgroup is a helper method to sort the species names,
+starting with the outgroups (in the order they were given).  This is
+used to format the xread block with the outgroup as the first species
+in the list.
+*/
+func (t *Matrix) sortByOutgroup() {
+	if len(t.Outgroups) == 0 {
+		return
+	}
+	t.speciesNames = t.Taxa()
+}
+
+// insertString into the place that would keep it uniquely and ordered ascending
+func insertString(slice []string, s string) []string {
+	i := sort.SearchStrings(slice, s)
+	// Inserstion sort of the species names: builds up the list as a sorted list
+	if i < len(slice) && slice[i] != s {
+		// Species Name not in the list; insert it at i
+		slice = append(slice[:i], append([]string{s}, slice[i:]...)...)
+	} else if i == len(slice) {
+		slice = append(slice, s)
+	}
+	return slice
+}
+
+// AddSequence (or multiple) to the internal sequence store.  If there is
+// already a sequence for the gene and species, the Duplicates policy picks
+// which to keep.
+func (t *Matrix) AddSequence(seqs ...sequence.Sequence) {
+	for _, seq := range seqs {
+		if t.Sequences == nil {
+			t.Sequences = make(map[string]map[string]sequence.Sequence)
+		}
+		if m, ok := t.Sequences[seq.Gene]; !ok || m == nil {
+			t.Sequences[seq.Gene] = make(map[string]sequence.Sequence)
+		}
+		if existing, ok := t.Sequences[seq.Gene][seq.Species]; ok {
+			seq = t.addDuplicate(existing, seq)
+		}
+		t.Sequences[seq.Gene][seq.Species] = seq
+		t.speciesNames = insertString(t.speciesNames, seq.Species)
+	}
+}
+
+// Clone returns a copy of the matrix, which can be written out (filling in
+// the missing data, and sorting the outgroups) without changing this one.
+// The sequences themselves are shared; nothing changes them.
+func (t *Matrix) Clone() Matrix {
+	clone := Matrix{
+		Sequences:    make(map[string]map[string]sequence.Sequence, len(t.Sequences)),
+		speciesNames: append([]string{}, t.speciesNames...),
+		Outgroups:    append([]string{}, t.Outgroups...),
+		Duplicates:   t.Duplicates,
+		Collisions:   t.Collisions,
+	}
+	for gene, seqs := range t.Sequences {
+		clone.Sequences[gene] = make(map[string]sequence.Sequence, len(seqs))
+		for name, seq := range seqs {
+			clone.Sequences[gene][name] = seq
+		}
+	}
+	return clone
+}
+
+// AllSequences returns all the sequences in the matrix, ordered by gene and
+// then species (in the order of Taxa, so the outgroups come first).  This
+// is how a format which is read in as a matrix hands its sequences on to
+// the other formats.
+func (t *Matrix) AllSequences() []sequence.Sequence {
+	genes := make([]string, 0, len(t.Sequences))
+	for gene, _ := range t.Sequences {
+		genes = append(genes, gene)
+	}
+	sort.Strings(genes)
+
+	taxa := t.Taxa()
+	seqs := make([]sequence.Sequence, 0, len(genes)*len(taxa))
+	for _, gene := range genes {
+		for _, name := range taxa {
+			if seq, ok := t.Sequences[gene][name]; ok {
+				seqs = append(seqs, seq)
+			}
+		}
+	}
+	return seqs
+}
+
+/*
+SequenceType returns the type shared by all the (non blank) sequences in
+the matrix.  If they are not all the same, or the type can't be figured
+out, this is sequence.UNSUPPORTED_TYPE
+*/
+func (t *Matrix) SequenceType() sequence.SequenceType {
+	first := true
+	var seqType sequence.SequenceType
+	for gene, _ := range t.Sequences {
+		for _, seq := range t.Sequences[gene] {
+			if seq.Type() == sequence.BLANK_TYPE {
+				continue
+			}
+			if first {
+				first = false
+				seqType = seq.Type()
+			}
+			if seq.Type() != seqType || seq.Type() == sequence.UNSUPPORTED_TYPE {
+				return sequence.UNSUPPORTED_TYPE
+			}
+		}
+	}
+	if first {
+		return sequence.BLANK_TYPE
+	}
+	return seqType
+}
+
+func geneLength(lengths map[int][]string) (max int) {
+	for i, _ := range lengths {
+		if i > max {
+			max = i
+		}
+	}
+	return
+}
+
+// fmtInvalidSequenceErr will return a specialized error for invalid
+// sequence lengths.
+func fmtInvalidSequenceErr(sequenceName string, lengths map[int][]string) error {
+	sorted := make([]int, 0, len(lengths))
+	for length, _ := range lengths {
+		sorted = append(sorted, length)
+	}
+	sort.Ints(sorted)
+	details := []string{}
+	for _, length := range sorted {
+		details = append(details, fmt.Sprintf("\t%d: %s", length, strings.Join(lengths[length], ", ")))
+	}
+
+	detailedMessage := fmt.Sprintf("Sequence %s has inconsistant sequence lengths:\n%s", sequenceName, strings.Join(details, "\n"))
+	return sequence.InvalidSequence{
+		Message: "Sequences are not the Same length",
+		Details: detailedMessage,
+		Errno:   sequence.MISSMATCHED_SEQUENCE_LENGTHS,
+	}
+}
+
+/*
+GenerateMetaData will make sure that the sequences for the same
+gene sequence (or whatever sequence) are all the same length.
+Returns types of InvalidSequence with ErrNo
+MISSMATCHED_SEQUENCE_LENGTHS if they are no correct
+If they are correct, it will return a slice of the gene meta data
+GeneMetaData, sequence.GMDSlice
+
+If a sequence is zero; it is not counted as bad.  It  needs to be
+cleaned up with a call to CleanData
+
+This will also set the max lenght sequence size; which is used
+by some helper functions
+*/
+func (t *Matrix) GenerateMetaData() (sequence.GMDSlice, error) {
+	geneMetaData := make(sequence.GMDSlice, 0, len(t.Sequences))
+
+	for gene, _ := range t.Sequences {
+		lengths := make(map[int][]string)
+		for _, name := range t.speciesNames {
+			seq := t.Sequences[gene][name]
+			if seq.Length > t.maxSequenceLength {
+				t.maxSequenceLength = seq.Length
+			}
+			if _, ok := lengths[seq.Length]; ok {
+				lengths[seq.Length] = append(lengths[seq.Length], seq.Name)
+			} else {
+				lengths[seq.Length] = []string{seq.Name}
+			}
+		}
+		_, hasZero := lengths[0]
+		if (len(lengths) > 2) || (len(lengths) > 1 && !hasZero) {
+			return nil, fmtInvalidSequenceErr(gene, lengths)
+		}
+
+		if hasZero {
+			t.dirtyData = true
+		}
+		geneMetaData = append(geneMetaData, sequence.GeneMetaData{
+			Gene:          gene,
+			Length:        geneLength(lengths),
+			NumberSpecies: len(t.Sequences[gene]),
+		})
+	}
+	t.MetaData = geneMetaData
+	geneMetaData.Sort()
+	return geneMetaData, nil
+}
+
+// geneRange is the (zero indexed) span of a gene within the concatenated
+// matrix; Start is inclusive and End is exclusive
+type geneRange struct {
+	Gene       string
+	Start, End int
+}
+
+// geneRanges returns the positions of each gene, in the order given by the
+// MetaData; which is the same order the genes are concatenated in
+func (t *Matrix) geneRanges() []geneRange {
+	ranges := make([]geneRange, 0, len(t.MetaData))
+	var start int
+	for _, gmd := range t.MetaData {
+		ranges = append(ranges, geneRange{Gene: gmd.Gene, Start: start, End: start + gmd.Length})
+		start = start + gmd.Length
+	}
+	return ranges
+}
+
+// getTotalLength will return the combined length of all genes.  This should
+// be the same for each species.
+func (t *Matrix) getTotalLength() (length int) {
+	for _, gmd := range t.MetaData {
+		length = length + gmd.Length
+	}
+	return
+}
+
+/*
+SetOutgroup will set the outgroup (or outgroups) under test.  This sorts
+them to the top of the list of taxa in the xread block.
+
+The outgroups must be species that have already been added; if one isn't,
+an InvalidSequence error with the Errno UNKNOWN_SPECIES is returned, with
+the closest species names in the details, and no outgroup is set.  Setting
+no species clears the outgroup.
+*/
+func (t *Matrix) SetOutgroup(species ...string) error {
+	known := make(map[string]bool, len(t.speciesNames))
+	for _, n := range t.speciesNames {
+		known[sequence.Safe(n)] = true
+	}
+	details := []string{}
+	for _, og := range species {
+		if known[sequence.Safe(og)] {
+			continue
+		}
+		matches := sequence.CloseMatches(og, t.speciesNames)
+		if len(matches) == 0 {
+			details = append(details, fmt.Sprintf("\t%s: no similar species", og))
+		} else {
+			details = append(details, fmt.Sprintf("\t%s: did you mean %s?", og, strings.Join(matches, ", ")))
+		}
+	}
+	if len(details) > 0 {
+		return sequence.InvalidSequence{
+			Message: "Outgroup is not one of the species",
+			Details: fmt.Sprintf("These outgroups are not in the input:\n%s", strings.Join(details, "\n")),
+			Errno:   sequence.UNKNOWN_SPECIES,
+		}
+	}
+	t.Outgroups = species
+	return nil
+}
+
+/*
+blankSequence returns a slice of '---' bytes (or the missing character, if
+the format has one).  Does this by pre-allocating
+
+the longest that could be returned, and slicing up subsets of it to be returned.
+this should be fine because once returned, they should never be modified,
+so the shared memory should not be a problem
+*/
+func (t *Matrix) blankSequence(n int) sequence.SequenceData {
+	missing := t.missing
+	if missing == 0 {
+		missing = '-'
+	}
+	if t.blankSeq == nil || len(t.blankSeq) < t.maxSequenceLength || (len(t.blankSeq) > 0 && t.blankSeq[0] != missing) {
+		t.blankSeq = make(sequence.SequenceData, t.maxSequenceLength, t.maxSequenceLength)
+		for i, _ := range t.blankSeq {
+			t.blankSeq[i] = missing
+		}
+	}
+
+	return t.blankSeq[:n]
+}
+
+/*
+CleanData will fill in missing data.
+*/
+func (t *Matrix) CleanData() {
+	for _, gmd := range t.MetaData {
+		for _, name := range t.speciesNames {
+			seq := t.Sequences[gmd.Gene][name]
+			if len(seq.Seq) == 0 {
+				seq.Seq = t.blankSequence(gmd.Length)
+				seq.Length = gmd.Length
+				t.Sequences[gmd.Gene][name] = seq
+			}
+		}
+	}
+
+}
+
+// prepare will generate the meta data and fill in missing data, ready for
+// one of the concatenated formats to be written out.
+func (t *Matrix) prepare() error {
+	if err := t.DuplicatesError(); err != nil {
+		return err
+	}
+	if _, err := t.GenerateMetaData(); err != nil {
+		return err
+	}
+	if t.dirtyData {
+		t.CleanData()
+	}
+	return nil
+}
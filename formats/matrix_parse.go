@@ -0,0 +1,61 @@
+package formats
+
+import (
+	"bytes"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// matrixRow is the data for one taxon when reading in a concatenated
+// format, in the internal `[AG]` polymorphism notation.  length is the
+// logical length.
+type matrixRow struct {
+	name   string
+	data   []byte
+	length int
+}
+
+// charSet is a named set of (zero indexed) character positions; used to
+// split a concatenated matrix back up into genes
+type charSet struct {
+	name       string
+	positions  []int
+	contiguous bool
+}
+
+// splitRows splits up the rows into a sequence per taxon per charSet; the
+// name of the charSet is used as the gene
+func splitRows(rows []*matrixRow, partitions []charSet) []sequence.Sequence {
+	seqs := make([]sequence.Sequence, 0, len(partitions)*len(rows))
+	for _, row := range rows {
+		var chars []sequence.SequenceData
+		if bytes.IndexByte(row.data, '[') != -1 {
+			chars = sequence.SequenceData(row.data).Characters()
+		}
+		for _, partition := range partitions {
+			data := make([]byte, 0, len(partition.positions))
+			for _, i := range partition.positions {
+				if chars == nil {
+					data = append(data, row.data[i])
+				} else {
+					data = append(data, chars[i]...)
+				}
+			}
+			seq := sequence.NewSequence(row.name, data)
+			seq.Species = row.name
+			seq.Gene = partition.name
+			seqs = append(seqs, seq)
+		}
+	}
+	return seqs
+}
+
+// contiguousCharSet is a charSet of every position from start up to (but
+// not including) end
+func contiguousCharSet(name string, start, end int) charSet {
+	positions := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		positions = append(positions, i)
+	}
+	return charSet{name: name, positions: positions, contiguous: true}
+}
@@ -0,0 +1,207 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alecthomas/template"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// Nexus formatter; writes out the concatenated genes as a TAXA, CHARACTERS
+// and SETS block.  Each gene gets its own charset, so it can be used
+// directly as a partition in MrBayes or PAUP*
+type Nexus struct {
+	Matrix
+}
+
+const NEXUS_FORMAT = "nexus"
+
+const (
+	NEXUS_GAP     = '-'
+	NEXUS_MISSING = '?'
+)
+
+const nexusTemplateString = `#NEXUS
+
+BEGIN TAXA;
+	DIMENSIONS NTAX={{ .NTaxa }};
+	TAXLABELS
+{{ range $i, $taxon := .Taxa }}		{{ $taxon.SpeciesName }}
+{{ end }}	;
+END;
+
+BEGIN CHARACTERS;
+	DIMENSIONS NCHAR={{ .Length }};
+	FORMAT{{ if .DataType }} DATATYPE={{ .DataType }}{{ end }} GAP={{ .Gap }} MISSING={{ .Missing }};
+	MATRIX
+{{ range $i, $taxon := .Taxa }}		{{ $taxon.SpeciesName }} {{ $taxon.Sequence }}
+{{ end }}	;
+END;
+
+BEGIN SETS;
+{{ range $i, $charset := .Charsets }}	CHARSET {{ $charset.Gene }} = {{ $charset.Start }}-{{ $charset.End }};
+{{ end }}END;
+`
+
+var nexusTemplate = template.Must(template.New("Nexus").Parse(nexusTemplateString))
+
+type nexusTemplateContext struct {
+	NTaxa, Length int
+	DataType      string
+	Gap, Missing  string
+	Taxa          []taxonData
+	Charsets      []geneRange
+}
+
+// nexusPunctuation is the set of characters that end a NEXUS token, so
+// names containing them must be quoted
+const nexusPunctuation = "()[]{}/\\,;:=*'\"`+-<>"
+
+// nexusName will return the name quoted, if it needs to be.  Single quotes
+// in the name are doubled, as per the NEXUS spec.
+func nexusName(name string) string {
+	if !strings.ContainsAny(name, nexusPunctuation) && !strings.ContainsAny(name, " \t\n") {
+		return name
+	}
+	return "'" + strings.Replace(name, "'", "''", -1) + "'"
+}
+
+// nexusSequence converts the TNT style polymorphisms `[AG]` into the
+// NEXUS style `{AG}`
+func nexusSequence(seq sequence.SequenceData) sequence.SequenceData {
+	if !bytes.ContainsAny(seq, "[]") {
+		return seq
+	}
+	converted := make(sequence.SequenceData, len(seq))
+	for i, c := range seq {
+		switch c {
+		case '[':
+			converted[i] = '{'
+		case ']':
+			converted[i] = '}'
+		default:
+			converted[i] = c
+		}
+	}
+	return converted
+}
+
+// nexusDataType is the DATATYPE for the FORMAT command; blank if we can't
+// work it out
+func nexusDataType(seqType sequence.SequenceType) string {
+	switch seqType {
+	case sequence.DNA_TYPE:
+		return "DNA"
+	case sequence.PROTEIN_TYPE:
+		return "PROTEIN"
+	case sequence.MORPHOLOGY_TYPE:
+		return "STANDARD"
+	default:
+		return ""
+	}
+}
+
+// dataTypeRange is the (zero indexed, end exclusive) characters of a type
+// in a MIXED DATATYPE
+type dataTypeRange struct {
+	dataType   string
+	start, end int
+}
+
+/*
+dataType is the DATATYPE of the matrix.  If the genes are of different
+types, it is written the way MrBayes reads mixed data, with the (one
+indexed) characters of each type:
+
+	DATATYPE=MIXED(DNA:1-11,PROTEIN:12-19)
+
+Blank genes (and a blank matrix) have no type of their own; they are
+joined on to the range before them, or the one after if they come first.
+A gene with more than one type of sequence in it can't be written out.
+*/
+func (n *Nexus) dataType() (string, error) {
+	seqType := n.SequenceType()
+	if seqType == sequence.BLANK_TYPE {
+		return nexusDataType(sequence.DNA_TYPE), nil
+	}
+	if seqType != sequence.UNSUPPORTED_TYPE {
+		return nexusDataType(seqType), nil
+	}
+	// the ranges of genes next to each other with the same type are joined
+	types := []dataTypeRange{}
+	for _, r := range n.geneRanges() {
+		geneType := n.sharedType(r.Gene)
+		if geneType == sequence.BLANK_TYPE {
+			if len(types) > 0 {
+				types[len(types)-1].end = r.End
+			}
+			continue
+		}
+		dataType := nexusDataType(geneType)
+		if dataType == "" {
+			return "", sequence.InvalidSequence{
+				Message: "Gene has more than one type of sequence",
+				Details: fmt.Sprintf("the NEXUS DATATYPE of %s can't be worked out; check its sequences are all DNA, protein or morphology", r.Gene),
+				Errno:   sequence.UNKNOWN,
+			}
+		}
+		if len(types) > 0 && types[len(types)-1].dataType == dataType {
+			types[len(types)-1].end = r.End
+			continue
+		}
+		// the first range also has any blank genes before it
+		start := r.Start
+		if len(types) == 0 {
+			start = 0
+		}
+		types = append(types, dataTypeRange{dataType: dataType, start: start, end: r.End})
+	}
+	parts := make([]string, len(types))
+	for i, r := range types {
+		parts[i] = fmt.Sprintf("%s:%d-%d", r.dataType, r.start+1, r.end)
+	}
+	return fmt.Sprintf("MIXED(%s)", strings.Join(parts, ",")), nil
+}
+
+// WriteSequences will collect up the sequences, verify their validity,
+// and output a formated NEXUS file to the supplied writer.  Genes a taxon
+// doesn't have are filled in as missing, not gaps.
+func (n *Nexus) WriteSequences(writer io.Writer) error {
+	n.missing = NEXUS_MISSING
+	if err := n.prepare(); err != nil {
+		return err
+	}
+	allSpecies, err := n.PrintableTaxa()
+	if err != nil {
+		return err
+	}
+	for i, _ := range allSpecies {
+		allSpecies[i].SpeciesName = nexusName(allSpecies[i].SpeciesName)
+		allSpecies[i].Sequence = nexusSequence(allSpecies[i].Sequence)
+	}
+
+	// charsets are 1 indexed and inclusive in NEXUS
+	charsets := n.geneRanges()
+	for i, _ := range charsets {
+		charsets[i].Gene = nexusName(charsets[i].Gene)
+		charsets[i].Start = charsets[i].Start + 1
+	}
+
+	dataType, err := n.dataType()
+	if err != nil {
+		return err
+	}
+	context := nexusTemplateContext{
+		NTaxa:    len(allSpecies),
+		Length:   n.getTotalLength(),
+		DataType: dataType,
+		Gap:      string(NEXUS_GAP),
+		Missing:  string(NEXUS_MISSING),
+		Taxa:     allSpecies,
+		Charsets: charsets,
+	}
+	return nexusTemplate.Execute(writer, context)
+}
@@ -0,0 +1,464 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yarbelk/refasta/scanner"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// nexusParser holds the state needed while reading through a NEXUS file
+type nexusParser struct {
+	words       *wordScanner
+	ntax, nchar int
+	interleaved bool
+	gap         rune
+	missing     rune
+	matchChar   rune
+	rows        []*matrixRow
+	rowIndex    map[string]int
+	charsets    []charSet
+}
+
+func (p *nexusParser) formatError(details string, args ...interface{}) error {
+	line, column := p.words.Position()
+	return sequence.FormatError{
+		Message: "Badly formated NEXUS file",
+		Details: fmt.Sprintf(details, args...),
+		Errno:   sequence.BAD_FORMAT,
+		Line:    line,
+		Column:  column,
+	}
+}
+
+/*
+Parse will read a NEXUS file, and add the sequences in it to the matrix.
+It understands the TAXA, CHARACTERS and DATA blocks, and the charset
+commands from SETS, ASSUMPTIONS and MRBAYES blocks; everything else is
+skipped.
+
+The MATRIX is split back up into a sequence per gene using the charsets.
+Overlapping charsets (such as codon positions) are ignored in favour of the
+contiguous ones.  If there are no charsets, or some characters are not in any
+charset, they are put in a gene called geneName.
+*/
+func (n *Nexus) Parse(input io.Reader, geneName ...string) error {
+	var gene string
+	if len(geneName) == 1 {
+		gene = geneName[0]
+	}
+	p := &nexusParser{
+		words:     newWordScanner(input, ";=,", true),
+		gap:       '-',
+		missing:   '?',
+		matchChar: '.',
+		rowIndex:  make(map[string]int),
+	}
+
+	header, _, err := p.words.Word()
+	if err != nil || strings.ToUpper(header) != "#NEXUS" {
+		return p.formatError("File must start with #NEXUS")
+	}
+
+	for {
+		command, err := p.words.Command()
+		if err == io.EOF && len(command) == 0 {
+			break
+		}
+		if err != nil {
+			return p.formatError("%s", err.Error())
+		}
+		if len(command) != 2 || strings.ToUpper(command[0]) != "BEGIN" {
+			return p.formatError("Expected 'BEGIN <block>;', got '%s'", strings.Join(command, " "))
+		}
+		if err := p.parseBlock(strings.ToUpper(command[1])); err != nil {
+			return err
+		}
+	}
+
+	if len(p.rows) == 0 {
+		return p.formatError("No CHARACTERS or DATA block with a MATRIX")
+	}
+	if err := p.resolveMatchChars(); err != nil {
+		return err
+	}
+	n.AddSequence(splitRows(p.rows, p.partitions(gene))...)
+	return nil
+}
+
+// parseBlock reads the commands in a block until its END;
+func (p *nexusParser) parseBlock(block string) error {
+	isData := block == "CHARACTERS" || block == "DATA"
+	for {
+		word, _, err := p.words.Word()
+		if err != nil {
+			return p.formatError("Block %s is missing its END;", block)
+		}
+		name := strings.ToUpper(word)
+		if name == ";" {
+			continue
+		}
+		if name == "MATRIX" && isData {
+			// The MATRIX data has its own rules, so it can't be read as words
+			if err := p.parseMatrix(); err != nil {
+				return err
+			}
+			continue
+		}
+		args, err := p.words.Command()
+		if err != nil {
+			return p.formatError("Block %s is missing its END;", block)
+		}
+		switch {
+		case name == "END", name == "ENDBLOCK":
+			return nil
+		case isData:
+			err = p.parseCharactersCommand(name, args)
+		case block == "SETS" || block == "ASSUMPTIONS" || block == "MRBAYES":
+			if name == "CHARSET" {
+				err = p.parseCharset(args)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// keyValues splits up the `KEY=VALUE KEY` style arguments of DIMENSIONS
+// and FORMAT.  Keys are upper cased.
+func keyValues(args []string) map[string]string {
+	values := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		key := strings.ToUpper(args[i])
+		if i+2 < len(args) && args[i+1] == "=" {
+			values[key] = args[i+2]
+			i = i + 2
+		} else {
+			values[key] = ""
+		}
+	}
+	return values
+}
+
+func (p *nexusParser) parseCharactersCommand(name string, args []string) error {
+	var err error
+	switch name {
+	case "DIMENSIONS":
+		values := keyValues(args)
+		if ntax, ok := values["NTAX"]; ok {
+			if p.ntax, err = strconv.Atoi(ntax); err != nil {
+				return p.formatError("NTAX must be a number, was '%s'", ntax)
+			}
+		}
+		if p.nchar, err = strconv.Atoi(values["NCHAR"]); err != nil {
+			return p.formatError("NCHAR must be a number, was '%s'", values["NCHAR"])
+		}
+	case "FORMAT":
+		values := keyValues(args)
+		if interleave, ok := values["INTERLEAVE"]; ok {
+			p.interleaved = interleave == "" || strings.ToUpper(interleave) == "YES"
+		}
+		if _, ok := values["TRANSPOSE"]; ok {
+			return p.formatError("TRANSPOSEd matrices are not supported")
+		}
+		if _, ok := values["NOLABELS"]; ok {
+			return p.formatError("MATRIX without taxon labels is not supported")
+		}
+		for key, symbol := range map[string]*rune{"GAP": &p.gap, "MISSING": &p.missing, "MATCHCHAR": &p.matchChar} {
+			if value, ok := values[key]; ok && len(value) == 1 {
+				*symbol = rune(value[0])
+			}
+		}
+	}
+	return nil
+}
+
+// parseMatrix reads the taxa and their data, up to the closing ';'.  In an
+// interleaved matrix each line has a name, and the data on that line is
+// added to what we have for that name.  Otherwise the data continues until
+// we have NCHAR characters.
+func (p *nexusParser) parseMatrix() error {
+	if p.nchar == 0 {
+		return p.formatError("MATRIX must come after DIMENSIONS NCHAR=...")
+	}
+	for {
+		ch, err := p.words.peek()
+		if err != nil {
+			return p.formatError("MATRIX is missing its closing ';'")
+		}
+		if ch == ';' {
+			p.words.read()
+			break
+		}
+		name, quoted, err := p.words.Word()
+		if err != nil {
+			return p.formatError("%s", err.Error())
+		}
+		if !quoted {
+			// underscores are blanks in unquoted NEXUS words
+			name = strings.Replace(name, "_", " ", -1)
+		}
+		index, ok := p.rowIndex[name]
+		if !ok {
+			index = len(p.rows)
+			p.rowIndex[name] = index
+			p.rows = append(p.rows, &matrixRow{name: name})
+		}
+		if err := p.readData(p.rows[index]); err != nil {
+			return err
+		}
+	}
+	return p.checkMatrix()
+}
+
+// readData reads the characters for a row; converting the NEXUS gap and
+// missing symbols into '-' and '?', and polymorphisms into `[AG]`
+func (p *nexusParser) readData(row *matrixRow) error {
+	for p.interleaved || row.length < p.nchar {
+		ch, err := p.words.read()
+		switch {
+		case err != nil:
+			return nil
+		case ch == '\n' && p.interleaved:
+			return nil
+		case scanner.IsWhitespace(ch):
+			continue
+		case ch == '[':
+			if err := p.words.skipComment(); err != nil {
+				return p.formatError("%s", err.Error())
+			}
+		case ch == ';':
+			p.words.unread()
+			return nil
+		case ch == '{' || ch == '(':
+			group, err := p.readPolymorphism(ch)
+			if err != nil {
+				return err
+			}
+			row.data = append(row.data, group...)
+			row.length++
+		case ch == p.gap:
+			row.data = append(row.data, '-')
+			row.length++
+		case ch == p.missing:
+			row.data = append(row.data, '?')
+			row.length++
+		case ch == p.matchChar:
+			row.data = append(row.data, '.')
+			row.length++
+		case ch < 0x80 && scanner.IsSequenceData(ch):
+			row.data = append(row.data, byte(ch))
+			row.length++
+		default:
+			return p.formatError("Unexpected '%c' in the data for '%s'", ch, row.name)
+		}
+	}
+	return nil
+}
+
+// readPolymorphism reads a `{AG}` or `(A,G)` group, which has already had
+// its opening bracket read, and returns it as `[AG]`
+func (p *nexusParser) readPolymorphism(open rune) ([]byte, error) {
+	close := '}'
+	if open == '(' {
+		close = ')'
+	}
+	group := []byte{'['}
+	for {
+		ch, err := p.words.read()
+		switch {
+		case err != nil:
+			return nil, p.formatError("Unbalanced %c%c in the data", open, close)
+		case ch == close:
+			if len(group) == 2 {
+				// {A} is just A
+				return group[1:], nil
+			}
+			return append(group, ']'), nil
+		case scanner.IsWhitespace(ch), ch == ',':
+			continue
+		case ch < 0x80 && scanner.IsSequenceData(ch):
+			group = append(group, byte(ch))
+		default:
+			return nil, p.formatError("Unexpected '%c' in a polymorphism", ch)
+		}
+	}
+}
+
+// checkMatrix makes sure we got the number of taxa and characters that
+// were promised in the DIMENSIONS
+func (p *nexusParser) checkMatrix() error {
+	if p.ntax != 0 && p.ntax != len(p.rows) {
+		return p.formatError("NTAX is %d, but the MATRIX has %d taxa", p.ntax, len(p.rows))
+	}
+	bad := []string{}
+	for _, row := range p.rows {
+		if row.length != p.nchar {
+			bad = append(bad, fmt.Sprintf("\t%s: %d", row.name, row.length))
+		}
+	}
+	if len(bad) > 0 {
+		return p.formatError("NCHAR is %d, but these taxa have a different number of characters:\n%s", p.nchar, strings.Join(bad, "\n"))
+	}
+	return nil
+}
+
+// resolveMatchChars replaces the match character with the character from
+// the first taxon at the same position
+func (p *nexusParser) resolveMatchChars() error {
+	var first []sequence.SequenceData
+	for i, row := range p.rows {
+		if bytes.IndexByte(row.data, '.') == -1 {
+			continue
+		}
+		if i == 0 {
+			return p.formatError("The first taxon '%s' can't use the MATCHCHAR", row.name)
+		}
+		if first == nil {
+			first = sequence.SequenceData(p.rows[0].data).Characters()
+		}
+		resolved := make([]byte, 0, len(row.data))
+		for j, c := range sequence.SequenceData(row.data).Characters() {
+			if len(c) == 1 && c[0] == '.' {
+				c = first[j]
+			}
+			resolved = append(resolved, c...)
+		}
+		row.data = resolved
+	}
+	return nil
+}
+
+var charsetRangeRegex = regexp.MustCompile(`^(\d+|\.)(?:-(\d+|\.))?(?:\\(\d+))?$`)
+var charsetSpaceRegex = regexp.MustCompile(`\s*([-\\])\s*`)
+
+/*
+parseCharset reads the arguments of a `CHARSET name = 1-10 12 20-.\3;`
+command.  Other charsets can be referenced by name, if they have already
+been defined.
+*/
+func (p *nexusParser) parseCharset(args []string) error {
+	if len(args) > 0 && args[0] == "*" {
+		args = args[1:]
+	}
+	equals := -1
+	for i, arg := range args {
+		if arg == "=" {
+			equals = i
+			break
+		}
+	}
+	if equals < 1 {
+		return p.formatError("Expected 'CHARSET name = ...;'")
+	}
+	name := args[0]
+
+	spec := charsetSpaceRegex.ReplaceAllString(strings.Join(args[equals+1:], " "), "$1")
+	positions := []int{}
+	contiguous := true
+	for _, item := range strings.Fields(spec) {
+		if other := p.findCharset(item); other != nil {
+			positions = append(positions, other.positions...)
+			contiguous = false
+			continue
+		}
+		match := charsetRangeRegex.FindStringSubmatch(item)
+		if match == nil {
+			return p.formatError("Don't understand '%s' in charset %s", item, name)
+		}
+		start, end, step := p.charsetPosition(match[1]), p.charsetPosition(match[1]), 1
+		if match[2] != "" {
+			end = p.charsetPosition(match[2])
+		}
+		if match[3] != "" {
+			step, _ = strconv.Atoi(match[3])
+			if step > 1 {
+				contiguous = false
+			}
+		}
+		if start < 1 || end > p.nchar || start > end || step < 1 {
+			return p.formatError("Charset %s has an invalid range '%s'", name, item)
+		}
+		for i := start; i <= end; i = i + step {
+			positions = append(positions, i-1)
+		}
+	}
+	sort.Ints(positions)
+	for i := 1; i < len(positions) && contiguous; i++ {
+		contiguous = positions[i] == positions[i-1]+1
+	}
+	p.charsets = append(p.charsets, charSet{name: name, positions: positions, contiguous: contiguous})
+	return nil
+}
+
+// charsetPosition converts a charset position to an int; '.' is the last
+// character
+func (p *nexusParser) charsetPosition(position string) int {
+	if position == "." {
+		return p.nchar
+	}
+	i, _ := strconv.Atoi(position)
+	return i
+}
+
+func (p *nexusParser) findCharset(name string) *charSet {
+	for i, _ := range p.charsets {
+		if p.charsets[i].name == name {
+			return &p.charsets[i]
+		}
+	}
+	return nil
+}
+
+// partitions picks the charsets to split the matrix up by.  Contiguous
+// charsets are prefered, and any charset which overlaps one already picked
+// is skipped.  Any characters left over are put in a charset called gene
+func (p *nexusParser) partitions(gene string) []charSet {
+	if len(p.charsets) == 0 {
+		return []charSet{contiguousCharSet(gene, 0, p.nchar)}
+	}
+	candidates := make([]charSet, 0, len(p.charsets))
+	for _, contiguous := range []bool{true, false} {
+		for _, charset := range p.charsets {
+			if charset.contiguous == contiguous {
+				candidates = append(candidates, charset)
+			}
+		}
+	}
+
+	used := make([]bool, p.nchar)
+	chosen := []charSet{}
+candidates:
+	for _, charset := range candidates {
+		for _, i := range charset.positions {
+			if used[i] {
+				fmt.Fprintf(os.Stderr, "charset %s overlaps another charset; it will not be used as a gene\n", charset.name)
+				continue candidates
+			}
+		}
+		for _, i := range charset.positions {
+			used[i] = true
+		}
+		chosen = append(chosen, charset)
+	}
+
+	leftOver := charSet{name: gene}
+	for i, u := range used {
+		if !u {
+			leftOver.positions = append(leftOver.positions, i)
+		}
+	}
+	if len(leftOver.positions) > 0 {
+		fmt.Fprintf(os.Stderr, "%d characters are not in any charset; they will be in the gene '%s'\n", len(leftOver.positions), gene)
+		chosen = append(chosen, leftOver)
+	}
+	return chosen
+}
@@ -0,0 +1,152 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+const sequentialNexus = `#NEXUS
+[ written by hand ]
+BEGIN TAXA;
+	DIMENSIONS NTAX=2;
+	TAXLABELS Homo_erectus 'Homo sapiens';
+END;
+
+BEGIN CHARACTERS;
+	DIMENSIONS NCHAR=19;
+	FORMAT DATATYPE=DNA GAP=- MISSING=?;
+	MATRIX
+		Homo_erectus TAGCATAGCTA
+			ATAGCTAC
+		'Homo sapiens' TAGCATAGCT{GA} ATAG[comment]CTAG
+	;
+END;
+
+BEGIN SETS;
+	CHARSET ATP6 = 1-11;
+	CHARSET ATP8 = 12 - 19;
+	CHARSET third = 3-.\3;
+END;
+`
+
+const interleavedNexus = `#NEXUS
+BEGIN DATA;
+	DIMENSIONS NTAX=2 NCHAR=12;
+	FORMAT DATATYPE=DNA INTERLEAVE MATCHCHAR=.;
+	MATRIX
+		Homo_erectus ATAGCT
+		Homo_sapiens .....(A,C)
+
+		Homo_erectus ACGTAC
+		Homo_sapiens ....G.
+	;
+END;
+`
+
+func nexusSequencesByKey(nexus *formats.Nexus) map[string]sequence.Sequence {
+	seqs := make(map[string]sequence.Sequence)
+	for _, seq := range nexus.AllSequences() {
+		seqs[seq.Gene+"/"+seq.Species] = seq
+	}
+	return seqs
+}
+
+func TestNexusParseSequentialSplitsByCharset(t *testing.T) {
+	nexus := &formats.Nexus{}
+	if err := nexus.Parse(bytes.NewBufferString(sequentialNexus), "mito"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]string{
+		"ATP6/Homo erectus": "TAGCATAGCTA",
+		"ATP8/Homo erectus": "ATAGCTAC",
+		"ATP6/Homo sapiens": "TAGCATAGCT[GA]",
+		"ATP8/Homo sapiens": "ATAGCTAG",
+	}
+	got := nexusSequencesByKey(nexus)
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d sequences, got %d: %v", len(expected), len(got), got)
+	}
+	for key, data := range expected {
+		if string(got[key].Seq) != data {
+			t.Errorf("Expected %s to be '%s', got '%s'", key, data, got[key].Seq)
+		}
+	}
+	if got["ATP6/Homo sapiens"].Length != 11 {
+		t.Errorf("Expected the polymorphism to count as one character, length was %d", got["ATP6/Homo sapiens"].Length)
+	}
+}
+
+func TestNexusParseInterleavedWithMatchChar(t *testing.T) {
+	nexus := &formats.Nexus{}
+	if err := nexus.Parse(bytes.NewBufferString(interleavedNexus), "co1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]string{
+		"co1/Homo erectus": "ATAGCTACGTAC",
+		"co1/Homo sapiens": "ATAGC[AC]ACGTGC",
+	}
+	got := nexusSequencesByKey(nexus)
+	for key, data := range expected {
+		if string(got[key].Seq) != data {
+			t.Errorf("Expected %s to be '%s', got '%s'", key, data, got[key].Seq)
+		}
+	}
+}
+
+func TestNexusParseWrongNCharIsFormatError(t *testing.T) {
+	input := `#NEXUS
+BEGIN DATA;
+	DIMENSIONS NTAX=2 NCHAR=4;
+	FORMAT INTERLEAVE;
+	MATRIX
+		A ATAG
+		B ATA
+	;
+END;
+`
+	nexus := &formats.Nexus{}
+	err := nexus.Parse(bytes.NewBufferString(input))
+
+	if err == nil {
+		t.Fatalf("Expected error to be; error was <nil>")
+	}
+
+	if err.(sequence.FormatError).Errno != sequence.BAD_FORMAT {
+		t.Errorf("Expected Errno to be '%d', was '%d'",
+			sequence.BAD_FORMAT,
+			err.(sequence.FormatError).Errno)
+	}
+}
+
+func TestNexusRoundTrip(t *testing.T) {
+	sequence1 := sequence.NewSequence("Homo sapiens", []byte("ATAGCT[AC]G"))
+	sequence1.Species = "Homo sapiens"
+	sequence1.Gene = "ATP8"
+
+	sequence2 := sequence.NewSequence("Homo sapiens", []byte("TAGCA"))
+	sequence2.Species = "Homo sapiens"
+	sequence2.Gene = "ATP6"
+
+	written := &formats.Nexus{}
+	written.AddSequence(sequence1, sequence2)
+	buf := bytes.Buffer{}
+	if err := written.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	read := &formats.Nexus{}
+	if err := read.Parse(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got := nexusSequencesByKey(read)
+	for _, seq := range []sequence.Sequence{sequence1, sequence2} {
+		if string(got[seq.Gene+"/"+seq.Species].Seq) != string(seq.Seq) {
+			t.Errorf("Expected %s to round trip as '%s', got '%s'", seq.Gene, seq.Seq, got[seq.Gene+"/"+seq.Species].Seq)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package formats_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func TestNexusTwoGenesTwoSpeciesFullOutput(t *testing.T) {
+	sequence1 := sequence.NewSequence("Homo sapiens", []byte("ATAGCTAG"))
+	sequence1.Species = "Homo sapiens"
+	sequence1.Gene = "ATP8"
+
+	sequence2 := sequence.NewSequence("Homo erectus", []byte("ATAGCTAC"))
+	sequence2.Species = "Homo erectus"
+	sequence2.Gene = "ATP8"
+
+	sequence3 := sequence.NewSequence("Homo sapiens", []byte("TAGCATAGCTG"))
+	sequence3.Species = "Homo sapiens"
+	sequence3.Gene = "ATP6"
+
+	sequence4 := sequence.NewSequence("Homo erectus", []byte("TAGCATAGCTA"))
+	sequence4.Species = "Homo erectus"
+	sequence4.Gene = "ATP6"
+
+	expected := `#NEXUS
+
+BEGIN TAXA;
+	DIMENSIONS NTAX=2;
+	TAXLABELS
+		Homo_erectus
+		Homo_sapiens
+	;
+END;
+
+BEGIN CHARACTERS;
+	DIMENSIONS NCHAR=19;
+	FORMAT DATATYPE=DNA GAP=- MISSING=?;
+	MATRIX
+		Homo_erectus TAGCATAGCTAATAGCTAC
+		Homo_sapiens TAGCATAGCTGATAGCTAG
+	;
+END;
+
+BEGIN SETS;
+	CHARSET ATP6 = 1-11;
+	CHARSET ATP8 = 12-19;
+END;
+`
+
+	nexus := &formats.Nexus{}
+	nexus.AddSequence(sequence1, sequence2, sequence3, sequence4)
+
+	buf := bytes.Buffer{}
+
+	if err := nexus.WriteSequences(&buf); err != nil {
+		t.Error("Expected no error, got one", err)
+	}
+
+	got := buf.String()
+	if got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+}
+
+func TestNexusConvertsPolymorphismsAndQuotesNames(t *testing.T) {
+	sequence1 := sequence.NewSequence("Homo sapiens", []byte("ATAGCT[AC]G"))
+	sequence1.Species = "Homo sapiens"
+	sequence1.Gene = "co1-5'"
+
+	sequence2 := sequence.NewSequence("Homo erectus", []byte("ATAGCTAC"))
+	sequence2.Species = "Homo erectus"
+	sequence2.Gene = "co1-5'"
+
+	nexus := &formats.Nexus{}
+	nexus.AddSequence(sequence1, sequence2)
+
+	buf := bytes.Buffer{}
+
+	if err := nexus.WriteSequences(&buf); err != nil {
+		t.Error("Expected no error, got one", err)
+	}
+
+	got := buf.String()
+	for _, expected := range []string{
+		"Homo_sapiens ATAGCT{AC}G\n",
+		"CHARSET 'co1-5''' = 1-8;\n",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("Expected output to contain %q, got:\n\n%s", expected, got)
+		}
+	}
+}
+
+func TestNexusDataTypeFromSequenceType(t *testing.T) {
+	sequence1 := sequence.NewSequence("A a", []byte("SSSGSKIADT"))
+	sequence1.Species = "A a"
+	sequence1.Gene = "cytb"
+
+	nexus := &formats.Nexus{}
+	nexus.AddSequence(sequence1)
+
+	buf := bytes.Buffer{}
+
+	if err := nexus.WriteSequences(&buf); err != nil {
+		t.Error("Expected no error, got one", err)
+	}
+
+	expected := "FORMAT DATATYPE=PROTEIN GAP=- MISSING=?;"
+	if got := buf.String(); !strings.Contains(got, expected) {
+		t.Errorf("Expected output to contain %q, got:\n\n%s", expected, got)
+	}
+}
+
+func TestNexusFillsAbsentGenesAsMissing(t *testing.T) {
+	sequence1 := sequence.NewSequence("Homo sapiens", []byte("ATAG-TAG"))
+	sequence1.Species = "Homo sapiens"
+	sequence1.Gene = "ATP8"
+
+	sequence2 := sequence.NewSequence("Homo erectus", []byte("TAGCA"))
+	sequence2.Species = "Homo erectus"
+	sequence2.Gene = "ATP6"
+
+	nexus := &formats.Nexus{}
+	nexus.AddSequence(sequence1, sequence2)
+
+	buf := bytes.Buffer{}
+
+	if err := nexus.WriteSequences(&buf); err != nil {
+		t.Error("Expected no error, got one", err)
+	}
+
+	got := buf.String()
+	for _, expected := range []string{
+		"Homo_erectus TAGCA????????\n",
+		"Homo_sapiens ?????ATAG-TAG\n",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("Expected output to contain %q, got:\n\n%s", expected, got)
+		}
+	}
+}
+
+func TestNexusMixedDataType(t *testing.T) {
+	dna := sequence.NewSequence("A a", []byte("ATAGCTAG"))
+	dna.Species = "A a"
+	dna.Gene = "ATP8"
+
+	protein := sequence.NewSequence("A a", []byte("SSSGSKIADT"))
+	protein.Species = "A a"
+	protein.Gene = "cytb"
+
+	morphology := sequence.NewSequence("A a", []byte("0120"))
+	morphology.Species = "A a"
+	morphology.Gene = "morph"
+
+	moreDNA := sequence.NewSequence("A a", []byte("TAGCAT"))
+	moreDNA.Species = "A a"
+	moreDNA.Gene = "ATP6"
+
+	nexus := &formats.Nexus{}
+	nexus.AddSequence(dna, protein, morphology, moreDNA)
+
+	buf := bytes.Buffer{}
+
+	if err := nexus.WriteSequences(&buf); err != nil {
+		t.Error("Expected no error, got one", err)
+	}
+
+	expected := "FORMAT DATATYPE=MIXED(DNA:1-14,PROTEIN:15-24,STANDARD:25-28) GAP=- MISSING=?;"
+	if got := buf.String(); !strings.Contains(got, expected) {
+		t.Errorf("Expected output to contain %q, got:\n\n%s", expected, got)
+	}
+}
+
+func TestNexusMixedDataTypeJoinsBlankGenes(t *testing.T) {
+	first := sequence.NewSequence("A a", []byte("---"))
+	first.Species = "A a"
+	first.Gene = "A0"
+
+	dna := sequence.NewSequence("A a", []byte("ATAGCTAG"))
+	dna.Species = "A a"
+	dna.Gene = "ATP8"
+
+	blank := sequence.NewSequence("A a", []byte("----"))
+	blank.Species = "A a"
+	blank.Gene = "COX2"
+
+	protein := sequence.NewSequence("A a", []byte("SSSGSKIADT"))
+	protein.Species = "A a"
+	protein.Gene = "cytb"
+
+	nexus := &formats.Nexus{}
+	nexus.AddSequence(first, dna, blank, protein)
+
+	buf := bytes.Buffer{}
+	if err := nexus.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "FORMAT DATATYPE=MIXED(DNA:1-15,PROTEIN:16-25) GAP=- MISSING=?;"
+	if got := buf.String(); !strings.Contains(got, expected) {
+		t.Errorf("Expected output to contain %q, got:\n\n%s", expected, got)
+	}
+}
+
+func TestNexusGeneWithMixedTypesIsAnError(t *testing.T) {
+	dna := sequence.NewSequence("A a", []byte("ATAGCTAGCT"))
+	dna.Species = "A a"
+	dna.Gene = "cytb"
+
+	protein := sequence.NewSequence("B b", []byte("SSSGSKIADT"))
+	protein.Species = "B b"
+	protein.Gene = "cytb"
+
+	nexus := &formats.Nexus{}
+	nexus.AddSequence(dna, protein)
+
+	buf := bytes.Buffer{}
+	if err := nexus.WriteSequences(&buf); err == nil {
+		t.Errorf("Expected an error, got:\n\n%s", buf.String())
+	}
+}
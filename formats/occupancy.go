@@ -0,0 +1,132 @@
+package formats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+/*
+Occupancy is which species have data for which genes, before the missing
+genes are filled in with gaps.  Coverage is keyed by species and then gene;
+it is the fraction of the gene's characters that the species has data for
+(not gaps or missing), and is 0 if the species doesn't have the gene at
+all.
+*/
+type Occupancy struct {
+	Genes    []string
+	Species  []string
+	Coverage map[string]map[string]float64
+}
+
+// OCCUPANCY_SHADES are the heatmap characters, from no coverage up to full
+const OCCUPANCY_SHADES = " .:+#"
+
+/*
+Occupancy works out the coverage of each gene by each species.  It has to
+be called before the matrix is written out, as that fills in the missing
+genes.
+*/
+func (t *Matrix) Occupancy() (Occupancy, error) {
+	if _, err := t.GenerateMetaData(); err != nil {
+		return Occupancy{}, err
+	}
+	occupancy := Occupancy{
+		Genes:    make([]string, 0, len(t.MetaData)),
+		Species:  append([]string{}, t.speciesNames...),
+		Coverage: make(map[string]map[string]float64, len(t.speciesNames)),
+	}
+	for _, name := range t.speciesNames {
+		occupancy.Coverage[name] = make(map[string]float64, len(t.MetaData))
+	}
+	for _, gmd := range t.MetaData {
+		occupancy.Genes = append(occupancy.Genes, gmd.Gene)
+		nucleotide := t.geneType(gmd.Gene) == sequence.DNA_TYPE
+		for _, name := range t.speciesNames {
+			seq, ok := t.Sequences[gmd.Gene][name]
+			if !ok || len(seq.Seq) == 0 || gmd.Length == 0 {
+				continue
+			}
+			present := 0
+			for _, char := range seq.Seq.Characters() {
+				if !missingChar(char, nucleotide) {
+					present++
+				}
+			}
+			occupancy.Coverage[name][gmd.Gene] = float64(present) / float64(gmd.Length)
+		}
+	}
+	return occupancy, nil
+}
+
+// genes is the number of genes the species has any data for
+func (o Occupancy) genes(species string) (present int) {
+	for _, coverage := range o.Coverage[species] {
+		if coverage > 0 {
+			present++
+		}
+	}
+	return
+}
+
+// shade is the heatmap character for the coverage
+func shade(coverage float64) byte {
+	if coverage <= 0 {
+		return OCCUPANCY_SHADES[0]
+	}
+	steps := len(OCCUPANCY_SHADES) - 1
+	i := int(math.Ceil(coverage * float64(steps)))
+	if i > steps {
+		i = steps
+	}
+	return OCCUPANCY_SHADES[i]
+}
+
+// WriteCSV writes a row per species, with the coverage of each gene and
+// the number of genes it has data for
+func (o Occupancy) WriteCSV(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(append(append([]string{"species"}, o.Genes...), "genes"))
+	for _, species := range o.Species {
+		row := []string{species}
+		for _, gene := range o.Genes {
+			row = append(row, strconv.FormatFloat(o.Coverage[species][gene], 'f', -1, 64))
+		}
+		csvWriter.Write(append(row, strconv.Itoa(o.genes(species))))
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+/*
+WriteHeatmap writes the occupancy as a text heatmap; a row per species and
+a column per gene, shaded by coverage with OCCUPANCY_SHADES (a blank is a
+missing gene, and # is over 75%).
+
+	species       ATP6  ATP8  morph  genes
+	Homo erectus  ####  ####  +++++  3/3
+	Homo sapiens  ####        #####  2/3
+*/
+func (o Occupancy) WriteHeatmap(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "species\t%s\tgenes\n", strings.Join(o.Genes, "\t"))
+	for _, species := range o.Species {
+		cells := make([]string, len(o.Genes))
+		for i, gene := range o.Genes {
+			cells[i] = strings.Repeat(string(shade(o.Coverage[species][gene])), len(gene))
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d/%d\n", species, strings.Join(cells, "\t"), o.genes(species), len(o.Genes))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\ncoverage: '%c' none, '%c' up to 25%%, '%c' up to 50%%, '%c' up to 75%%, '%c' over 75%%\n",
+		OCCUPANCY_SHADES[0], OCCUPANCY_SHADES[1], OCCUPANCY_SHADES[2], OCCUPANCY_SHADES[3], OCCUPANCY_SHADES[4])
+	return err
+}
@@ -0,0 +1,52 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+)
+
+func TestOccupancyIsBeforeBlankFilling(t *testing.T) {
+	matrix := &formats.Matrix{}
+	matrix.AddSequence(
+		validateSequence("A a", "ATP8", "ATAG"),
+		validateSequence("B b", "ATP8", "AT--"),
+		validateSequence("A a", "COX1", "MKLV"),
+	)
+	occupancy, err := matrix.Occupancy()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := occupancy.WriteCSV(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := "species,ATP8,COX1,genes\nA a,1,1,2\nB b,0.5,0,1\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func TestOccupancyHeatmap(t *testing.T) {
+	matrix := &formats.Matrix{}
+	matrix.AddSequence(
+		validateSequence("A a", "ATP8", "ATAG"),
+		validateSequence("B b", "ATP8", "AT--"),
+		validateSequence("A a", "COX1", "MKLV"),
+	)
+	occupancy, _ := matrix.Occupancy()
+
+	buf := bytes.Buffer{}
+	if err := occupancy.WriteHeatmap(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := "species  ATP8  COX1  genes\n" +
+		"A a      ####  ####  2/2\n" +
+		"B b      ::::        1/2\n" +
+		"\ncoverage: ' ' none, '.' up to 25%, ':' up to 50%, '+' up to 75%, '#' over 75%\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
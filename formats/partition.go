@@ -0,0 +1,169 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// partition is a set of characters in the concatenated matrix, given as
+// (one indexed, inclusive) Start to End, taking every Step'th character.
+// This is how both RAxML and NEXUS charsets describe them.
+type partition struct {
+	Name       string
+	Type       sequence.SequenceType
+	Start, End int
+	Step       int
+}
+
+// Range formats the partition as `1-11`, or `1-11\3` for codon positions
+func (p partition) Range() string {
+	if p.Step > 1 {
+		return fmt.Sprintf("%d-%d\\%d", p.Start, p.End, p.Step)
+	}
+	return fmt.Sprintf("%d-%d", p.Start, p.End)
+}
+
+/*
+geneType returns the type shared by the (non blank) sequences of a gene.
+Blank genes are counted as DNA, as that is what we mostly deal with.
+*/
+func (t *Matrix) geneType(gene string) sequence.SequenceType {
+	if geneType := t.sharedType(gene); geneType != sequence.BLANK_TYPE {
+		return geneType
+	}
+	return sequence.DNA_TYPE
+}
+
+// sharedType is the type shared by the (non blank) sequences of a gene;
+// BLANK_TYPE if they are all blank
+func (t *Matrix) sharedType(gene string) sequence.SequenceType {
+	geneType := sequence.BLANK_TYPE
+	for _, seq := range t.Sequences[gene] {
+		seqType := seq.Type()
+		switch {
+		case seqType == sequence.BLANK_TYPE:
+			continue
+		case geneType == sequence.BLANK_TYPE:
+			geneType = seqType
+		case seqType != geneType:
+			return sequence.UNSUPPORTED_TYPE
+		}
+	}
+	return geneType
+}
+
+/*
+partitions returns a partition per gene, in the order they are written
+out in the matrix.  If codonPositions is set, the DNA genes are split up
+into three partitions; one per codon position (`ATP6_1`, `ATP6_2` and
+`ATP6_3`).  Protein genes are never split.
+*/
+func (t *Matrix) partitions(codonPositions bool) ([]partition, error) {
+	if t.MetaData == nil {
+		if _, err := t.GenerateMetaData(); err != nil {
+			return nil, err
+		}
+	}
+	partitions := make([]partition, 0, len(t.MetaData))
+	for _, r := range t.geneRanges() {
+		if r.End == r.Start {
+			continue
+		}
+		geneType := t.geneType(r.Gene)
+		name := sequence.Safe(r.Gene)
+		if !codonPositions || geneType != sequence.DNA_TYPE {
+			partitions = append(partitions, partition{Name: name, Type: geneType, Start: r.Start + 1, End: r.End, Step: 1})
+			continue
+		}
+		for position := 1; position <= 3 && r.Start+position <= r.End; position++ {
+			partitions = append(partitions, partition{
+				Name:  fmt.Sprintf("%s_%d", name, position),
+				Type:  geneType,
+				Start: r.Start + position,
+				End:   r.End,
+				Step:  3,
+			})
+		}
+	}
+	return partitions, nil
+}
+
+// raxmlModel is the data type (or model) RAxML uses for a partition
+func raxmlModel(p partition) (string, error) {
+	switch p.Type {
+	case sequence.DNA_TYPE:
+		return "DNA", nil
+	case sequence.PROTEIN_TYPE:
+		return "LG", nil
+	case sequence.MORPHOLOGY_TYPE:
+		// states 0 to 9
+		return "MULTI10_MK", nil
+	default:
+		return "", sequence.InvalidSequence{
+			Message: "Can't write a partition for this data",
+			Details: fmt.Sprintf("gene %s is either mixed DNA/PROT, or an unsupported type", p.Name),
+			Errno:   sequence.UNKNOWN,
+		}
+	}
+}
+
+/*
+WriteRAxMLPartitions writes out a partition file for RAxML-NG (or RAxML),
+with a partition for each gene in the matrix.  DNA genes use `DNA`,
+protein genes use `LG` and morphology uses `MULTI10_MK`; these can be
+changed by hand to the models you want.
+
+	DNA, ATP6 = 1-11
+	DNA, ATP8 = 12-19
+
+This should be called after WriteSequences, so that the partitions line
+up with the matrix that was written out.
+*/
+func (t *Matrix) WriteRAxMLPartitions(writer io.Writer, codonPositions bool) error {
+	partitions, err := t.partitions(codonPositions)
+	if err != nil {
+		return err
+	}
+	for _, p := range partitions {
+		model, err := raxmlModel(p)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(writer, "%s, %s = %s\n", model, p.Name, p.Range()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+WriteIQTreePartitions writes out a NEXUS partition file for IQ-TREE (`-p`
+or `-q`), with a charset for each gene in the matrix.
+
+	#NEXUS
+	BEGIN SETS;
+		CHARSET ATP6 = 1-11;
+		CHARSET ATP8 = 12-19;
+	END;
+
+This should be called after WriteSequences, so that the partitions line
+up with the matrix that was written out.
+*/
+func (t *Matrix) WriteIQTreePartitions(writer io.Writer, codonPositions bool) error {
+	partitions, err := t.partitions(codonPositions)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(writer, "#NEXUS\nBEGIN SETS;\n"); err != nil {
+		return err
+	}
+	for _, p := range partitions {
+		if _, err := fmt.Fprintf(writer, "\tCHARSET %s = %s;\n", nexusName(p.Name), p.Range()); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(writer, "END;\n")
+	return err
+}
@@ -0,0 +1,72 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func TestRAxMLPartitionsMatchBlocks(t *testing.T) {
+	tnt := &formats.TNT{}
+	tnt.AddSequence(phylipTestSequences()...)
+
+	buf := bytes.Buffer{}
+	if err := tnt.WriteSequences(&bytes.Buffer{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tnt.WriteRAxMLPartitions(&buf, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := `DNA, ATP6 = 1-11
+DNA, ATP8 = 12-19
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+}
+
+func TestRAxMLPartitionsProtein(t *testing.T) {
+	seq := sequence.NewSequence("Homo sapiens", []byte("MKLVWQ"))
+	seq.Species = "Homo sapiens"
+	seq.Gene = "COX1"
+
+	phylip := &formats.Phylip{}
+	phylip.AddSequence(seq)
+
+	buf := bytes.Buffer{}
+	if err := phylip.WriteRAxMLPartitions(&buf, true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "LG, COX1 = 1-6\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+}
+
+func TestIQTreePartitionsByCodonPosition(t *testing.T) {
+	nexus := &formats.Nexus{}
+	nexus.AddSequence(phylipTestSequences()...)
+
+	buf := bytes.Buffer{}
+	if err := nexus.WriteIQTreePartitions(&buf, true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := `#NEXUS
+BEGIN SETS;
+	CHARSET ATP6_1 = 1-11\3;
+	CHARSET ATP6_2 = 2-11\3;
+	CHARSET ATP6_3 = 3-11\3;
+	CHARSET ATP8_1 = 12-19\3;
+	CHARSET ATP8_2 = 13-19\3;
+	CHARSET ATP8_3 = 14-19\3;
+END;
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+}
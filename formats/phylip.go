@@ -0,0 +1,200 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// Phylip formatter; writes out the concatenated genes for RAxML, IQ-TREE
+// and friends.  Strict PHYLIP only allows 10 characters for the names, so
+// they are shortened; WriteNameMap will write out what they were shortened
+// from.
+type Phylip struct {
+	Matrix
+	// Strict limits the names to 10 characters, padded out with spaces.
+	// Otherwise the name is separated from the data by a space (relaxed)
+	Strict bool
+	// Interleaved splits the data up into blocks of LineWidth characters
+	Interleaved bool
+	LineWidth   int
+	// shortNames maps the species name to the name written out
+	shortNames map[string]string
+}
+
+const PHYLIP_FORMAT = "phylip"
+
+const (
+	PHYLIP_STRICT_NAME_LENGTH = 10
+	PHYLIP_LINE_WIDTH         = 60
+)
+
+// phylipPunctuation can't be used in names, as it means something in the
+// newick trees that come out of the other end
+const phylipPunctuation = "()[]:;,'"
+
+// phylipName replaces the characters that can't be used in a name
+func phylipName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(phylipPunctuation, r) {
+			return '_'
+		}
+		return r
+	}, sequence.Safe(name))
+}
+
+/*
+phylipSequence converts polymorphisms, which PHYLIP doesn't have, into a
+single character.  For DNA this is the IUPAC code (eg: [AG] is R), for
+proteins it is X, and for anything else it is missing data.
+*/
+func phylipSequence(seq sequence.SequenceData, seqType sequence.SequenceType) sequence.SequenceData {
+	chars := seq.Characters()
+	if len(chars) == len(seq) {
+		return seq
+	}
+	converted := make(sequence.SequenceData, 0, len(chars))
+	for _, c := range chars {
+		switch {
+		case len(c) == 1:
+			converted = append(converted, c[0])
+		case seqType == sequence.DNA_TYPE:
+			converted = append(converted, sequence.IUPAC(c[1:len(c)-1]))
+		case seqType == sequence.PROTEIN_TYPE:
+			converted = append(converted, 'X')
+		default:
+			converted = append(converted, '?')
+		}
+	}
+	return converted
+}
+
+// makeShortNames works out the names to write for each species.  Names
+// that would end up the same (after being cut down to 10 characters in
+// strict mode) get a number on the end.
+func (p *Phylip) makeShortNames() {
+	p.shortNames = make(map[string]string, len(p.speciesNames))
+	used := make(map[string]bool, len(p.speciesNames))
+	for _, name := range p.speciesNames {
+		short := p.shortName(name, "")
+		for i := 1; used[short]; i++ {
+			short = p.shortName(name, strconv.Itoa(i))
+		}
+		used[short] = true
+		p.shortNames[name] = short
+	}
+}
+
+// shortName is the name with the suffix, cut down so it fits in a strict
+// PHYLIP name if needed.  It is cut by characters, not bytes; so a multi
+// byte character isn't split in half.
+func (p *Phylip) shortName(name, suffix string) string {
+	name = phylipName(name)
+	length := PHYLIP_STRICT_NAME_LENGTH - len(suffix)
+	if runes := []rune(name); p.Strict && len(runes) > length {
+		name = string(runes[:length])
+	}
+	return name + suffix
+}
+
+func (p *Phylip) lineWidth() int {
+	if p.LineWidth <= 0 {
+		return PHYLIP_LINE_WIDTH
+	}
+	return p.LineWidth
+}
+
+// label is the name as it is written before the data
+func (p *Phylip) label(species string) string {
+	if p.Strict {
+		return fmt.Sprintf("%-*s", PHYLIP_STRICT_NAME_LENGTH, p.shortNames[species])
+	}
+	return p.shortNames[species] + " "
+}
+
+/*
+WriteSequences will collect up the sequences, verify their validity, and
+output a PHYLIP file to the supplied writer.
+
+	2 19
+	Homo_erectTAGCATAGCTAATAGCTAC
+	Homo_sapieTAGCATAGCTGATAGCTAG
+
+Relaxed names are written in full, followed by a space.
+
+When interleaved, only the first block has the names, and the blocks are
+separated by a blank line.
+*/
+func (p *Phylip) WriteSequences(writer io.Writer) error {
+	if err := p.prepare(); err != nil {
+		return err
+	}
+	allSpecies, err := p.PrintableTaxa()
+	if err != nil {
+		return err
+	}
+	p.makeShortNames()
+	length := p.getTotalLength()
+	// the polymorphisms are converted by the type of their gene, so the DNA
+	// genes of a matrix that also has proteins still get their IUPAC codes
+	geneTypes := make([]sequence.SequenceType, len(p.MetaData))
+	for i, gmd := range p.MetaData {
+		geneTypes[i] = p.geneType(gmd.Gene)
+	}
+	for i, _ := range allSpecies {
+		converted := make(sequence.SequenceData, 0, length)
+		for j, gmd := range p.MetaData {
+			seq := p.Sequences[gmd.Gene][p.speciesNames[i]].Seq
+			converted = append(converted, phylipSequence(seq, geneTypes[j])...)
+		}
+		allSpecies[i].Sequence = converted
+	}
+
+	if _, err := fmt.Fprintf(writer, "%d %d\n", len(allSpecies), length); err != nil {
+		return err
+	}
+
+	width := length
+	if p.Interleaved {
+		width = p.lineWidth()
+	}
+	for start := 0; ; start = start + width {
+		end := start + width
+		if end > length {
+			end = length
+		}
+		if start != 0 {
+			if _, err := io.WriteString(writer, "\n"); err != nil {
+				return err
+			}
+		}
+		for i, taxon := range allSpecies {
+			label := ""
+			if start == 0 {
+				label = p.label(p.speciesNames[i])
+			}
+			if _, err := fmt.Fprintf(writer, "%s%s\n", label, taxon.Sequence[start:end]); err != nil {
+				return err
+			}
+		}
+		if end >= length {
+			break
+		}
+	}
+	return nil
+}
+
+// WriteNameMap writes out a tab separated table of the names used in the
+// PHYLIP file, and the species they came from.  This must be called after
+// WriteSequences.
+func (p *Phylip) WriteNameMap(writer io.Writer) error {
+	for _, name := range p.speciesNames {
+		if _, err := fmt.Fprintf(writer, "%s\t%s\n", p.shortNames[name], name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yarbelk/refasta/scanner"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// phylipLine is a non blank line of a PHYLIP file, and where it was
+type phylipLine struct {
+	number int
+	text   string
+}
+
+// phylipParser holds the state needed while reading through a PHYLIP file
+type phylipParser struct {
+	strict      bool
+	ntax, nchar int
+	lines       []phylipLine
+}
+
+// phylipError is a problem with the data, and where it is; the column is
+// 0 if it is the whole line, and the line is 0 if it is the whole file
+type phylipError struct {
+	line, column int
+	message      string
+}
+
+func (e phylipError) Error() string {
+	return e.message
+}
+
+func newPhylipError(line, column int, message string, args ...interface{}) error {
+	return phylipError{line: line, column: column, message: fmt.Sprintf(message, args...)}
+}
+
+func phylipFormatError(line, column int, details string, args ...interface{}) error {
+	return sequence.FormatError{
+		Message: "Badly formated PHYLIP file",
+		Details: fmt.Sprintf(details, args...),
+		Errno:   sequence.BAD_FORMAT,
+		Line:    line,
+		Column:  column,
+	}
+}
+
+// phylipColumn is the (one indexed) column of the byte offset in the text
+func phylipColumn(text string, offset int) int {
+	return utf8.RuneCountInString(text[:offset]) + 1
+}
+
+/*
+Parse will read a PHYLIP file, and add the sequences in it to the gene
+geneName.  Names are read as relaxed (up to the first space), unless Strict
+is set; in which case they are the first 10 characters.
+
+Whether the data is sequential or interleaved is worked out from the number
+of taxa and characters in the header; the file must match these exactly.
+*/
+func (p *Phylip) Parse(input io.Reader, geneName ...string) error {
+	var gene string
+	if len(geneName) == 1 {
+		gene = geneName[0]
+	}
+	parser := &phylipParser{strict: p.Strict}
+	if err := parser.readLines(input); err != nil {
+		return err
+	}
+	if err := parser.parseHeader(); err != nil {
+		return err
+	}
+
+	rows, err := parser.sequential()
+	if err != nil {
+		interleavedRows, interleavedErr := parser.interleaved()
+		if interleavedErr != nil {
+			// the one that got further is more likely to be the problem
+			where, _ := err.(phylipError)
+			if other, ok := interleavedErr.(phylipError); ok && other.line > where.line {
+				where = other
+			}
+			return phylipFormatError(where.line, where.column,
+				"Couldn't read the data as sequential or interleaved:\n\tsequential: %s\n\tinterleaved: %s",
+				err.Error(), interleavedErr.Error())
+		}
+		rows = interleavedRows
+	}
+	p.AddSequence(splitRows(rows, []charSet{contiguousCharSet(gene, 0, parser.nchar)})...)
+	return nil
+}
+
+// readLines reads all of the non blank lines; PHYLIP files are small enough
+// that this is simpler than streaming them
+func (p *phylipParser) readLines(input io.Reader) error {
+	reader := bufio.NewReader(input)
+	for number := 1; ; number++ {
+		line, err := reader.ReadString('\n')
+		if text := strings.TrimRight(line, "\r\n"); strings.TrimSpace(text) != "" {
+			p.lines = append(p.lines, phylipLine{number: number, text: text})
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseHeader reads the `NTAX NCHAR` line
+func (p *phylipParser) parseHeader() error {
+	if len(p.lines) == 0 {
+		return phylipFormatError(0, 0, "The file is empty")
+	}
+	header := p.lines[0]
+	fields := strings.Fields(header.text)
+	var err error
+	if len(fields) < 2 {
+		return phylipFormatError(header.number, 0, "the header must be the number of taxa and characters, got '%s'", header.text)
+	}
+	taxaStart := strings.Index(header.text, fields[0])
+	if p.ntax, err = strconv.Atoi(fields[0]); err != nil || p.ntax < 1 {
+		return phylipFormatError(header.number, phylipColumn(header.text, taxaStart), "the number of taxa must be a number, got '%s'", fields[0])
+	}
+	charStart := taxaStart + len(fields[0]) + strings.Index(header.text[taxaStart+len(fields[0]):], fields[1])
+	if p.nchar, err = strconv.Atoi(fields[1]); err != nil || p.nchar < 1 {
+		return phylipFormatError(header.number, phylipColumn(header.text, charStart), "the number of characters must be a number, got '%s'", fields[1])
+	}
+	p.lines = p.lines[1:]
+	return nil
+}
+
+// splitName splits a line up into the name, and where the data starts
+func (p *phylipParser) splitName(line phylipLine) (string, int, error) {
+	var name string
+	var start int
+	if p.strict {
+		// the name is 10 characters, which may be more than 10 bytes
+		characters := 0
+		for i := range line.text {
+			if characters == PHYLIP_STRICT_NAME_LENGTH {
+				start = i
+				break
+			}
+			characters++
+		}
+		if start == 0 {
+			return "", 0, newPhylipError(line.number, 0, "expected a 10 character name and data")
+		}
+		name = line.text[:start]
+	} else {
+		indent := len(line.text) - len(strings.TrimLeft(line.text, " \t"))
+		split := strings.IndexAny(line.text[indent:], " \t")
+		if split == -1 {
+			return "", 0, newPhylipError(line.number, 0, "expected a name and data")
+		}
+		name, start = line.text[indent:indent+split], indent+split
+	}
+	// PHYLIP names can't have spaces in relaxed mode; so they are written
+	// with underscores
+	return strings.Replace(strings.TrimSpace(name), "_", " ", -1), start, nil
+}
+
+// addData appends the data on a line, from start on, to the row
+func addData(row *matrixRow, line phylipLine, start int) error {
+	for i, ch := range line.text[start:] {
+		switch {
+		case scanner.IsWhitespace(ch):
+			continue
+		case ch < 0x80 && scanner.IsSequenceData(ch):
+			row.data = append(row.data, byte(ch))
+			row.length++
+		default:
+			return newPhylipError(line.number, phylipColumn(line.text, start+i), "unexpected '%c' in the data for '%s'", ch, row.name)
+		}
+	}
+	return nil
+}
+
+// nameRow starts a new row from a line with a name on it
+func (p *phylipParser) nameRow(line phylipLine) (*matrixRow, error) {
+	name, start, err := p.splitName(line)
+	if err != nil {
+		return nil, err
+	}
+	row := &matrixRow{name: name}
+	return row, addData(row, line, start)
+}
+
+// sequential reads the data as a name, followed by lines of data until
+// there are NCHAR characters; then the next name
+func (p *phylipParser) sequential() ([]*matrixRow, error) {
+	rows := make([]*matrixRow, 0, p.ntax)
+	i := 0
+	for len(rows) < p.ntax {
+		if i >= len(p.lines) {
+			return nil, newPhylipError(0, 0, "expected %d taxa, found %d", p.ntax, len(rows))
+		}
+		row, err := p.nameRow(p.lines[i])
+		if err != nil {
+			return nil, err
+		}
+		for i = i + 1; row.length < p.nchar && i < len(p.lines); i++ {
+			if err := addData(row, p.lines[i], 0); err != nil {
+				return nil, err
+			}
+		}
+		if row.length != p.nchar {
+			return nil, newPhylipError(p.lines[i-1].number, 0, "'%s' has %d characters, expected %d", row.name, row.length, p.nchar)
+		}
+		rows = append(rows, row)
+	}
+	if i != len(p.lines) {
+		return nil, newPhylipError(p.lines[i].number, 0, "expected the end of the file after %d taxa", p.ntax)
+	}
+	return rows, nil
+}
+
+// interleaved reads the data as a block of NTAX lines with names, followed
+// by blocks of NTAX lines of data
+func (p *phylipParser) interleaved() ([]*matrixRow, error) {
+	if len(p.lines)%p.ntax != 0 {
+		return nil, newPhylipError(0, 0, "there are %d lines, which isn't a multiple of %d taxa", len(p.lines), p.ntax)
+	}
+	rows := make([]*matrixRow, 0, p.ntax)
+	for i, line := range p.lines {
+		if i < p.ntax {
+			row, err := p.nameRow(line)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+			continue
+		}
+		if err := addData(rows[i%p.ntax], line, 0); err != nil {
+			return nil, err
+		}
+	}
+	for _, row := range rows {
+		if row.length != p.nchar {
+			return nil, newPhylipError(0, 0, "'%s' has %d characters, expected %d", row.name, row.length, p.nchar)
+		}
+	}
+	return rows, nil
+}
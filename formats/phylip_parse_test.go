@@ -0,0 +1,135 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func phylipSequencesBySpecies(phylip *formats.Phylip) map[string]string {
+	seqs := make(map[string]string)
+	for _, seq := range phylip.AllSequences() {
+		seqs[seq.Species] = string(seq.Seq)
+	}
+	return seqs
+}
+
+func TestPhylipParseSequentialAcrossLines(t *testing.T) {
+	input := `2 12
+Homo_sapiens ATAGCT
+ACGTAC
+Homo_erectus ATAG CTAC
+GTAC
+`
+	phylip := &formats.Phylip{}
+	if err := phylip.Parse(bytes.NewBufferString(input), "co1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]string{
+		"Homo sapiens": "ATAGCTACGTAC",
+		"Homo erectus": "ATAGCTACGTAC",
+	}
+	got := phylipSequencesBySpecies(phylip)
+	for species, data := range expected {
+		if got[species] != data {
+			t.Errorf("Expected %s to be '%s', got '%s'", species, data, got[species])
+		}
+	}
+	if gene := phylip.AllSequences()[0].Gene; gene != "co1" {
+		t.Errorf("Expected the gene to be 'co1', got '%s'", gene)
+	}
+}
+
+func TestPhylipParseStrictInterleaved(t *testing.T) {
+	input := ` 2 12
+Homo_sapieATAGCT
+Homo_erectATAGCT
+
+ACGTAC
+ACGTAG
+`
+	phylip := &formats.Phylip{Strict: true}
+	if err := phylip.Parse(bytes.NewBufferString(input), "co1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]string{
+		"Homo sapie": "ATAGCTACGTAC",
+		"Homo erect": "ATAGCTACGTAG",
+	}
+	got := phylipSequencesBySpecies(phylip)
+	for species, data := range expected {
+		if got[species] != data {
+			t.Errorf("Expected %s to be '%s', got '%s'", species, data, got[species])
+		}
+	}
+}
+
+func TestPhylipParseWrongHeaderIsFormatError(t *testing.T) {
+	input := `3 6
+Homo_sapiens ATAGCT
+Homo_erectus ATAGCT
+`
+	phylip := &formats.Phylip{}
+	err := phylip.Parse(bytes.NewBufferString(input))
+
+	if err == nil {
+		t.Fatalf("Expected error to be; error was <nil>")
+	}
+
+	if err.(sequence.FormatError).Errno != sequence.BAD_FORMAT {
+		t.Errorf("Expected Errno to be '%d', was '%d'",
+			sequence.BAD_FORMAT,
+			err.(sequence.FormatError).Errno)
+	}
+}
+
+func TestPhylipRoundTrip(t *testing.T) {
+	written := &formats.Phylip{Interleaved: true, LineWidth: 5}
+	written.AddSequence(phylipTestSequences()...)
+	buf := bytes.Buffer{}
+	if err := written.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	read := &formats.Phylip{}
+	if err := read.Parse(&buf, "all"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[string]string{
+		"Homo sapiens":                  "TAGCATAGCTGATAGCTRG",
+		"Homo sapiens neanderthalensis": "TAGCATAGCTAATAGCTAC",
+	}
+	got := phylipSequencesBySpecies(read)
+	for species, data := range expected {
+		if got[species] != data {
+			t.Errorf("Expected %s to be '%s', got '%s'", species, data, got[species])
+		}
+	}
+}
+
+func TestPhylipParseErrorPositions(t *testing.T) {
+	for _, test := range []struct {
+		input        string
+		line, column int
+	}{
+		{"2 six\nHomo_sapiens ATAGCT\nHomo_erectus ATAGCT\n", 1, 3},
+		{"2 6\nHomo_sapiens ATAGCT\nHomo_erectus ATA*CT\n", 3, 17},
+		{"2 6\n\n  Homo_sapiens ATAGCT\n  Homo_érectus ATA*CT\n", 4, 19},
+		{"2 6\nHomo_sapiens ATAGCT\nHomo_erectus ATAGCT\nHomo_erectus ATAGCT\n", 4, 0},
+	} {
+		phylip := &formats.Phylip{}
+		err := phylip.Parse(bytes.NewBufferString(test.input))
+		formatErr, ok := err.(sequence.FormatError)
+		if !ok {
+			t.Errorf("Expected a FormatError for %q, got %v", test.input, err)
+			continue
+		}
+		if formatErr.Line != test.line || formatErr.Column != test.column {
+			t.Errorf("Expected the error for %q at %d:%d, got %d:%d (%v)", test.input, test.line, test.column, formatErr.Line, formatErr.Column, err)
+		}
+	}
+}
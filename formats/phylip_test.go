@@ -0,0 +1,133 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func phylipTestSequences() []sequence.Sequence {
+	sequence1 := sequence.NewSequence("Homo sapiens", []byte("ATAGCT[AG]G"))
+	sequence1.Species = "Homo sapiens"
+	sequence1.Gene = "ATP8"
+
+	sequence2 := sequence.NewSequence("Homo sapiens neanderthalensis", []byte("ATAGCTAC"))
+	sequence2.Species = "Homo sapiens neanderthalensis"
+	sequence2.Gene = "ATP8"
+
+	sequence3 := sequence.NewSequence("Homo sapiens", []byte("TAGCATAGCTG"))
+	sequence3.Species = "Homo sapiens"
+	sequence3.Gene = "ATP6"
+
+	sequence4 := sequence.NewSequence("Homo sapiens neanderthalensis", []byte("TAGCATAGCTA"))
+	sequence4.Species = "Homo sapiens neanderthalensis"
+	sequence4.Gene = "ATP6"
+	return []sequence.Sequence{sequence1, sequence2, sequence3, sequence4}
+}
+
+func TestPhylipRelaxedSequential(t *testing.T) {
+	phylip := &formats.Phylip{}
+	phylip.AddSequence(phylipTestSequences()...)
+
+	buf := bytes.Buffer{}
+	if err := phylip.WriteSequences(&buf); err != nil {
+		t.Error("Expected no error, got one", err)
+	}
+
+	expected := `2 19
+Homo_sapiens TAGCATAGCTGATAGCTRG
+Homo_sapiens_neanderthalensis TAGCATAGCTAATAGCTAC
+`
+	got := buf.String()
+	if got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+}
+
+func TestPhylipStrictInterleavedWithNameMap(t *testing.T) {
+	phylip := &formats.Phylip{Strict: true, Interleaved: true, LineWidth: 10}
+	phylip.AddSequence(phylipTestSequences()...)
+
+	buf := bytes.Buffer{}
+	if err := phylip.WriteSequences(&buf); err != nil {
+		t.Error("Expected no error, got one", err)
+	}
+
+	expected := `2 19
+Homo_sapieTAGCATAGCT
+Homo_sapi1TAGCATAGCT
+
+GATAGCTRG
+AATAGCTAC
+`
+	got := buf.String()
+	if got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+
+	names := bytes.Buffer{}
+	if err := phylip.WriteNameMap(&names); err != nil {
+		t.Error("Expected no error, got one", err)
+	}
+	expectedNames := "Homo_sapie\tHomo sapiens\nHomo_sapi1\tHomo sapiens neanderthalensis\n"
+	if names.String() != expectedNames {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expectedNames, names.String())
+	}
+}
+
+func TestPhylipStrictNamesAreCutByCharacters(t *testing.T) {
+	sequence1 := sequence.NewSequence("Jaçanã spinosa", []byte("ATAGCTAG"))
+	sequence1.Species = "Jaçanã spinosa"
+	sequence1.Gene = "ATP8"
+
+	sequence2 := sequence.NewSequence("Crotalus durissus", []byte("ATAGCTAC"))
+	sequence2.Species = "Crotalus durissus"
+	sequence2.Gene = "ATP8"
+
+	written := &formats.Phylip{Strict: true}
+	written.AddSequence(sequence1, sequence2)
+	buf := bytes.Buffer{}
+	if err := written.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `2 8
+Crotalus_dATAGCTAC
+Jaçanã_spiATAGCTAG
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+
+	read := &formats.Phylip{Strict: true}
+	if err := read.Parse(&buf, "ATP8"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := phylipSequencesBySpecies(read); got["Jaçanã spi"] != "ATAGCTAG" {
+		t.Errorf("Expected 'Jaçanã spi' to read back as 'ATAGCTAG', got %v", got)
+	}
+}
+
+func TestPhylipConvertsPolymorphismsByGeneType(t *testing.T) {
+	dna := sequence.NewSequence("Homo sapiens", []byte("ATAG[AG]"))
+	dna.Species = "Homo sapiens"
+	dna.Gene = "ATP8"
+
+	protein := sequence.NewSequence("Homo sapiens", []byte("MPQL[LF]"))
+	protein.Species = "Homo sapiens"
+	protein.Gene = "COX1"
+
+	phylip := &formats.Phylip{}
+	phylip.AddSequence(dna, protein)
+	buf := bytes.Buffer{}
+	if err := phylip.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `1 10
+Homo_sapiens ATAGRMPQLX
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+}
@@ -0,0 +1,279 @@
+package formats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// STATS_TOTAL is the name of the concatenated matrix in the stats
+const STATS_TOTAL = "(concatenated)"
+
+/*
+GeneStats are the statistics of a gene, or of the whole concatenated
+matrix.  NumberSpecies is the number of taxa with some data for the gene.
+GC and Missing are percentages; GC is only set for DNA.
+*/
+type GeneStats struct {
+	sequence.GeneMetaData
+	Variable    int      `json:"variable_sites"`
+	Informative int      `json:"informative_sites"`
+	GC          *float64 `json:"gc,omitempty"`
+	Missing     float64  `json:"missing"`
+}
+
+// TaxonStats is how much of the concatenated matrix a taxon has data for;
+// Completeness is a percentage of the characters
+type TaxonStats struct {
+	Species      string  `json:"species"`
+	Genes        int     `json:"genes"`
+	Completeness float64 `json:"completeness"`
+}
+
+// Stats of all the genes, the concatenated matrix, and the taxa
+type Stats struct {
+	Genes []GeneStats  `json:"genes"`
+	Total GeneStats    `json:"total"`
+	Taxa  []TaxonStats `json:"taxa"`
+}
+
+// percent of part in whole, or 0 if there is no whole
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+// siteState is the state of a character for counting variable and
+// informative sites; polymorphisms, ambiguity codes, gaps and missing data
+// don't have one
+func siteState(char sequence.SequenceData, seqType sequence.SequenceType) (byte, bool) {
+	if len(char) != 1 {
+		return 0, false
+	}
+	c := char[0]
+	if c >= 'a' && c <= 'z' {
+		c = c - 'a' + 'A'
+	}
+	switch {
+	case c == '-', c == '?':
+		return 0, false
+	case seqType == sequence.DNA_TYPE:
+		return c, strings.IndexByte("ACGTU", c) >= 0
+	case seqType == sequence.PROTEIN_TYPE:
+		return c, c != 'X'
+	}
+	return c, true
+}
+
+// geneStats counts up the statistics for one gene; missingChars is the
+// number of missing characters of each taxon
+func (t *Matrix) geneStats(gmd sequence.GeneMetaData) (stats GeneStats, gc, at int, missingChars map[string]int) {
+	seqType := t.geneType(gmd.Gene)
+	nucleotide := seqType == sequence.DNA_TYPE
+	missingChars = make(map[string]int, len(t.speciesNames))
+	columns := make([]map[byte]int, gmd.Length)
+	for i := range columns {
+		columns[i] = make(map[byte]int)
+	}
+
+	stats.Gene, stats.Length = gmd.Gene, gmd.Length
+	for _, name := range t.speciesNames {
+		seq, ok := t.Sequences[gmd.Gene][name]
+		if !ok || len(seq.Seq) == 0 {
+			missingChars[name] = gmd.Length
+			continue
+		}
+		for i, char := range seq.Seq.Characters() {
+			if missingChar(char, nucleotide) {
+				missingChars[name]++
+			}
+			if i >= gmd.Length {
+				continue
+			}
+			if state, ok := siteState(char, seqType); ok {
+				columns[i][state]++
+			}
+			if nucleotide && len(char) == 1 {
+				switch char[0] {
+				case 'G', 'C', 'g', 'c':
+					gc++
+				case 'A', 'T', 'U', 'a', 't', 'u':
+					at++
+				}
+			}
+		}
+		if missingChars[name] < gmd.Length {
+			stats.NumberSpecies++
+		}
+	}
+
+	for _, column := range columns {
+		if len(column) > 1 {
+			stats.Variable++
+		}
+		common := 0
+		for _, count := range column {
+			if count > 1 {
+				common++
+			}
+		}
+		if common > 1 {
+			stats.Informative++
+		}
+	}
+	total := 0
+	for _, m := range missingChars {
+		total += m
+	}
+	stats.Missing = percent(total, gmd.Length*len(t.speciesNames))
+	if nucleotide {
+		content := percent(gc, gc+at)
+		stats.GC = &content
+	}
+	return
+}
+
+// missingChar is true for a gap, missing data, or an N in DNA
+func missingChar(char sequence.SequenceData, nucleotide bool) bool {
+	return missing(char) || (nucleotide && len(char) == 1 && (char[0] == 'N' || char[0] == 'n'))
+}
+
+/*
+Stats works out the statistics of each gene, the concatenated matrix, and
+how complete each taxon is.  Sites are only counted as variable, or
+parsimony informative, on their unambiguous states; gaps, missing data,
+polymorphisms and ambiguity codes are left out.  Taxa which don't have a
+gene count as missing data for it.
+*/
+func (t *Matrix) Stats() (Stats, error) {
+	if err := t.DuplicatesError(); err != nil {
+		return Stats{}, err
+	}
+	if _, err := t.GenerateMetaData(); err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{
+		Genes: make([]GeneStats, 0, len(t.MetaData)),
+		Taxa:  make([]TaxonStats, 0, len(t.speciesNames)),
+	}
+	stats.Total.Gene = STATS_TOTAL
+	stats.Total.NumberSpecies = len(t.speciesNames)
+
+	missingChars := make(map[string]int, len(t.speciesNames))
+	genes := make(map[string]int, len(t.speciesNames))
+	var gc, at, nucleotides int
+	for _, gmd := range t.MetaData {
+		geneStats, geneGC, geneAT, geneMissing := t.geneStats(gmd)
+		stats.Genes = append(stats.Genes, geneStats)
+		stats.Total.Length += geneStats.Length
+		stats.Total.Variable += geneStats.Variable
+		stats.Total.Informative += geneStats.Informative
+		if geneStats.GC != nil {
+			gc, at = gc+geneGC, at+geneAT
+			nucleotides++
+		}
+		for _, name := range t.speciesNames {
+			missingChars[name] += geneMissing[name]
+			if geneMissing[name] < gmd.Length {
+				genes[name]++
+			}
+		}
+	}
+
+	total := 0
+	for _, name := range t.speciesNames {
+		total += missingChars[name]
+		stats.Taxa = append(stats.Taxa, TaxonStats{
+			Species:      name,
+			Genes:        genes[name],
+			Completeness: 100 - percent(missingChars[name], stats.Total.Length),
+		})
+	}
+	stats.Total.Missing = percent(total, stats.Total.Length*len(t.speciesNames))
+	if nucleotides > 0 {
+		content := percent(gc, gc+at)
+		stats.Total.GC = &content
+	}
+	return stats, nil
+}
+
+// row is the gene stats as strings, for the table and CSV; none is
+// written for the GC content of genes which aren't DNA
+func (g GeneStats) row(precision int, none string) []string {
+	gc := none
+	if g.GC != nil {
+		gc = strconv.FormatFloat(*g.GC, 'f', precision, 64)
+	}
+	return []string{
+		g.Gene,
+		strconv.Itoa(g.NumberSpecies),
+		strconv.Itoa(g.Length),
+		strconv.Itoa(g.Variable),
+		strconv.Itoa(g.Informative),
+		gc,
+		strconv.FormatFloat(g.Missing, 'f', precision, 64),
+	}
+}
+
+var geneStatsHeader = []string{"gene", "taxa", "length", "variable_sites", "informative_sites", "gc", "missing"}
+var taxonStatsHeader = []string{"species", "genes", "completeness"}
+
+func (t TaxonStats) row(precision int) []string {
+	return []string{t.Species, strconv.Itoa(t.Genes), strconv.FormatFloat(t.Completeness, 'f', precision, 64)}
+}
+
+// WriteTable writes the stats as two aligned tables; the genes (and the
+// concatenated matrix), and the taxa
+func (s Stats) WriteTable(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, strings.Join(geneStatsHeader, "\t"))
+	for _, gene := range s.Genes {
+		fmt.Fprintln(table, strings.Join(gene.row(1, "-"), "\t"))
+	}
+	fmt.Fprintln(table, strings.Join(s.Total.row(1, "-"), "\t"))
+	fmt.Fprintln(table)
+	fmt.Fprintln(table, strings.Join(taxonStatsHeader, "\t"))
+	for _, taxon := range s.Taxa {
+		fmt.Fprintln(table, strings.Join(taxon.row(1), "\t"))
+	}
+	return table.Flush()
+}
+
+// WriteCSV writes either the gene stats (with the concatenated matrix as
+// the last row), or if taxa is set, the taxon stats
+func (s Stats) WriteCSV(w io.Writer, taxa bool) error {
+	rows := [][]string{}
+	if taxa {
+		rows = append(rows, taxonStatsHeader)
+		for _, taxon := range s.Taxa {
+			rows = append(rows, taxon.row(-1))
+		}
+	} else {
+		rows = append(rows, geneStatsHeader)
+		for _, gene := range s.Genes {
+			rows = append(rows, gene.row(-1, ""))
+		}
+		rows = append(rows, s.Total.row(-1, ""))
+	}
+	csvWriter := csv.NewWriter(w)
+	csvWriter.WriteAll(rows)
+	return csvWriter.Error()
+}
+
+// WriteJSON writes all the stats as JSON
+func (s Stats) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
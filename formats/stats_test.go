@@ -0,0 +1,68 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+)
+
+func statsMatrix() *formats.Matrix {
+	matrix := &formats.Matrix{}
+	matrix.AddSequence(
+		validateSequence("A a", "ATP8", "AACGT-"),
+		validateSequence("B b", "ATP8", "AACGTA"),
+		validateSequence("C c", "ATP8", "GTCGRA"),
+		validateSequence("D d", "ATP8", "GTCG?A"),
+		validateSequence("A a", "COX1", "MKLV"),
+		validateSequence("B b", "COX1", "MKIV"),
+	)
+	return matrix
+}
+
+func TestStats(t *testing.T) {
+	stats, err := statsMatrix().Stats()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(stats.Genes) != 2 {
+		t.Fatalf("Expected 2 genes, got %d", len(stats.Genes))
+	}
+
+	atp8 := stats.Genes[0]
+	// Sites 1 and 2 are informative (AA/GG and AA/TT); nothing else varies
+	if atp8.Gene != "ATP8" || atp8.NumberSpecies != 4 || atp8.Length != 6 || atp8.Variable != 2 || atp8.Informative != 2 {
+		t.Errorf("Expected ATP8 to have 4 taxa, 6 sites, 2 variable and 2 informative, got %+v", atp8)
+	}
+	// 10 of the 21 unambiguous bases are G or C
+	if atp8.GC == nil || *atp8.GC != 100*10.0/21 {
+		t.Errorf("Expected ATP8 to be %f%% GC, got %v", 100*10.0/21, atp8.GC)
+	}
+	if atp8.Missing != 100*2.0/24 {
+		t.Errorf("Expected ATP8 to be %f%% missing, got %f", 100*2.0/24, atp8.Missing)
+	}
+
+	cox1 := stats.Genes[1]
+	if cox1.NumberSpecies != 2 || cox1.Variable != 1 || cox1.Informative != 0 || cox1.GC != nil || cox1.Missing != 50 {
+		t.Errorf("Expected COX1 to have 2 taxa, 1 variable site, no GC and be 50%% missing, got %+v", cox1)
+	}
+
+	if stats.Total.Length != 10 || stats.Total.Variable != 3 || stats.Total.Informative != 2 {
+		t.Errorf("Expected the concatenated matrix to have 10 sites, 3 variable and 2 informative, got %+v", stats.Total)
+	}
+	if taxon := stats.Taxa[3]; taxon.Species != "D d" || taxon.Genes != 1 || taxon.Completeness != 50 {
+		t.Errorf("Expected 'D d' to have 1 gene and be 50%% complete, got %+v", taxon)
+	}
+}
+
+func TestStatsCSV(t *testing.T) {
+	stats, _ := statsMatrix().Stats()
+	buf := bytes.Buffer{}
+	if err := stats.WriteCSV(&buf, true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := "species,genes,completeness\nA a,2,90\nB b,2,100\nC c,1,60\nD d,1,50\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -13,21 +12,16 @@ import (
 )
 
 // TNT formatter
-// TODO move Sequences access to an interface; this way all formats
-// can use the same interface, and I can change the data structure
-// to be a slice, with a lookup map (`map[string]map[string]int`,
-// where the int is the index.)  This makes building aggregate data
-// much more understandable (no nexted loops).  It shouldn't impact
-// performance.
 type TNT struct {
-	Title             string
-	Sequences         map[string]map[string]sequence.Sequence
-	MetaData          sequence.GMDSlice
-	speciesNames      []string
-	Outgroup          string
-	dirtyData         bool
-	maxSequenceLength int
-	blankSeq          sequence.SequenceData
+	Matrix
+	Title string
+	// CharacterGroups are written out as xgroups, after the blocks
+	CharacterGroups []CharacterGroup
+	// CodonGroups adds the first, second and third codon positions of the
+	// DNA genes as character groups
+	CodonGroups bool
+	// CharacterCodes are written out as ccode commands, after the xread
+	CharacterCodes []CharacterCode
 }
 
 const tntNonInterleavedTemplateString = `xread
@@ -42,129 +36,91 @@ cnames
 {{ range $i, $cname := .Cnames}}{{ $cname }}
 {{ end }};`
 
+const tntInterleavedTemplateString = `xread
+'{{ .Title }}'
+{{ .Length }} {{ .NTaxa }}
+{{ range $i, $block := .Blocks }}&[{{ $block.DataType }}]
+{{ range $j, $taxon := $block.Taxa }}{{ $taxon.SpeciesName }} {{ $taxon.Sequence }}
+{{ end }}{{ end }};`
+
 var tntNonInterleavedTemplate = template.Must(template.New("TNTXread").Parse(tntNonInterleavedTemplateString))
+var tntInterleavedTemplate = template.Must(template.New("TNTInterleavedXread").Parse(tntInterleavedTemplateString))
 var tntBlocksTemplate = template.Must(template.New("TNTBlocks").Parse(tntBlocksTemplateString))
 
 type templateContext struct {
 	Title         string
 	Length, NTaxa int
 	Taxa          []taxonData
+	Blocks        []tntDataBlock
 }
 
-type taxonData struct {
-	SpeciesName string
-	Sequence    sequence.SequenceData
+// tntDataBlock is a section of an interleaved xread; a gene, and its type
+type tntDataBlock struct {
+	DataType string
+	Taxa     []taxonData
 }
 
 const TNT_FORMAT = "tnt"
 
-/*
-Construct a species using a GMDSlice to order the gene sequences.
-If there is a defined outgroup, then sort that to the front of the
-printable list
-*/
-func (t *TNT) PrintableTaxa() ([]taxonData, error) {
-	if t.MetaData == nil {
-		if _, err := t.GenerateMetaData(); err != nil {
-			return nil, err
-		}
-	}
-	var allSpecies []taxonData = make([]taxonData, 0, len(t.speciesNames))
-	t.sortByOutgroup()
-
-	for _, n := range t.speciesNames {
-		combinedSequences := make([]byte, 0, t.getTotalLength())
-		for _, gmd := range t.MetaData {
-			combinedSequences = append(combinedSequences, t.Sequences[gmd.Gene][n].Seq...)
-		}
-		allSpecies = append(allSpecies, taxonData{
-			SpeciesName: sequence.Safe(n),
-			Sequence:    combinedSequences,
-		})
+// tntDataType is the name TNT uses for a type of data in an interleaved
+// xread; `&[dna]`
+func tntDataType(seqType sequence.SequenceType) string {
+	switch seqType {
+	case sequence.DNA_TYPE:
+		return "dna"
+	case sequence.PROTEIN_TYPE:
+		return "prot"
+	case sequence.MORPHOLOGY_TYPE:
+		return "num"
+	default:
+		return ""
 	}
-	return allSpecies, nil
 }
 
 /*
-sortByOutgroup is a helper method to sort the species names,
-starting with the outgroup.  This is used to format the xread block
-with the outgroup as the first species in the list.
+mixedTypes returns the type of each gene (in the order they are written
+out) if they aren't all the same; otherwise nil.  If any of the genes are
+a type TNT can't read, it is also nil, and the xread is written as a
+single block.
 */
-func (t *TNT) sortByOutgroup() {
-	if t.Outgroup == "" {
-		return
-	}
-	var index int = 0
-	safeOG := sequence.Safe(t.Outgroup)
-	for j, n := range t.speciesNames {
-		if safeOG == sequence.Safe(n) {
-			index = j
-			break
+func (t *TNT) mixedTypes() []sequence.SequenceType {
+	types := make([]sequence.SequenceType, 0, len(t.MetaData))
+	mixed := false
+	for i, gmd := range t.MetaData {
+		geneType := t.geneType(gmd.Gene)
+		if tntDataType(geneType) == "" {
+			return nil
 		}
-	}
-
-	a := t.speciesNames[index]
-	t.speciesNames = append(
-		append(t.speciesNames[:1], t.speciesNames[0:index]...),
-		t.speciesNames[index+1:]...)
-	t.speciesNames[0] = a
-}
-
-// insertString into the place that would keep it uniquely and ordered ascending
-func insertString(slice []string, s string) []string {
-	i := sort.SearchStrings(slice, s)
-	// Inserstion sort of the species names: builds up the list as a sorted list
-	if i < len(slice) && slice[i] != s {
-		// Species Name not in the list; insert it at i
-		slice = append(slice[:i], append([]string{s}, slice[i:]...)...)
-	} else if i == len(slice) {
-		slice = append(slice, s)
-	}
-	return slice
-}
-
-// AddSequence (or multiple) to the internal sequence store.
-func (t *TNT) AddSequence(seqs ...sequence.Sequence) {
-	for _, seq := range seqs {
-		if t.Sequences == nil {
-			t.Sequences = make(map[string]map[string]sequence.Sequence)
-		}
-		if m, ok := t.Sequences[seq.Gene]; !ok || m == nil {
-			t.Sequences[seq.Gene] = make(map[string]sequence.Sequence)
+		if i > 0 && geneType != types[0] {
+			mixed = true
 		}
-		t.Sequences[seq.Gene][seq.Species] = seq
-		t.speciesNames = insertString(t.speciesNames, seq.Species)
+		types = append(types, geneType)
 	}
+	if !mixed {
+		return nil
+	}
+	return types
 }
 
 /*
-WriteNState header *if* we have a valid type.  Assume they are all the
-same state at the moment
+WriteNState header *if* we have a valid type.  If the genes are a mix of
+DNA, proteins and morphology, then each gene gets its own type in the
+xread; so we just make sure there are enough states for the proteins.
+Morphology is what TNT reads by default, so it doesn't need an nstates.
 */
 func (t *TNT) WriteNState(writer io.Writer) error {
-	first := true
-	var seqType sequence.SequenceType
-top:
-	for gene, _ := range t.Sequences {
-		for _, seq := range t.Sequences[gene] {
-			if first {
-				first = false
-				seqType = seq.Type()
-			}
-			if seq.Type() == sequence.BLANK_TYPE {
-				continue
-			}
-			if seq.Type() != seqType || seq.Type() == sequence.UNSUPPORTED_TYPE {
-				seqType = sequence.UNSUPPORTED_TYPE
-				break top
-			}
-		}
+	if t.mixedTypes() != nil {
+		writer.Write([]byte("nstates 32;\n"))
+		return nil
 	}
+	seqType := t.SequenceType()
 	switch seqType {
 	case sequence.DNA_TYPE:
 		writer.Write([]byte("nstates DNA;\n"))
 	case sequence.PROTEIN_TYPE:
 		writer.Write([]byte("nstates PROT;\n"))
+	case sequence.MORPHOLOGY_TYPE:
+		break
 	case sequence.UNSUPPORTED_TYPE:
 		fallthrough
 	default:
@@ -183,6 +139,18 @@ and taxa data
 	taxa_2 TAGCA...
 	;
 
+If the genes are of mixed types, then it is interleaved, with a section
+for each gene
+
+	xread
+	&[dna]
+	taxa_1 CTAGC...
+	taxa_2 TAGCA...
+	&[num]
+	taxa_1 0120...
+	taxa_2 0110...
+	;
+
 */
 func (t *TNT) WriteXRead(writer io.Writer) error {
 	allSpecies, err := t.PrintableTaxa()
@@ -195,7 +163,65 @@ func (t *TNT) WriteXRead(writer io.Writer) error {
 		NTaxa:  len(t.speciesNames),
 		Taxa:   allSpecies,
 	}
-	return tntNonInterleavedTemplate.Execute(writer, context)
+	types := t.mixedTypes()
+	if types == nil {
+		return tntNonInterleavedTemplate.Execute(writer, context)
+	}
+	for i, gmd := range t.MetaData {
+		block := tntDataBlock{
+			DataType: tntDataType(types[i]),
+			Taxa:     make([]taxonData, 0, len(t.speciesNames)),
+		}
+		for _, n := range t.speciesNames {
+			block.Taxa = append(block.Taxa, taxonData{
+				SpeciesName: sequence.Safe(n),
+				Sequence:    t.Sequences[gmd.Gene][n].Seq,
+			})
+		}
+		context.Blocks = append(context.Blocks, block)
+	}
+	return tntInterleavedTemplate.Execute(writer, context)
+}
+
+/*
+WriteOutgroup writes out the outgroup command, which refers to the taxon
+by its position in the xread block.  Nothing is written if there is no
+outgroup.
+
+	outgroup 0;
+
+TNT can only root on one taxon; so if there is more than one outgroup,
+the first is used, and they are all put in a taxon group named outgroup
+so they can be selected together.
+
+	agroup =0 (outgroup) 0 1;
+	outgroup 0;
+*/
+func (t *TNT) WriteOutgroup(writer io.Writer) error {
+	if len(t.Outgroups) == 0 {
+		return nil
+	}
+	t.sortByOutgroup()
+	outgroups := make(map[string]bool, len(t.Outgroups))
+	for _, og := range t.Outgroups {
+		outgroups[sequence.Safe(og)] = true
+	}
+	indexes := make([]string, 0, len(t.Outgroups))
+	for i, n := range t.speciesNames {
+		if outgroups[sequence.Safe(n)] {
+			indexes = append(indexes, strconv.Itoa(i))
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	if len(indexes) > 1 {
+		if _, err := fmt.Fprintf(writer, "\nagroup =0 (outgroup) %s;", strings.Join(indexes, " ")); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(writer, "\noutgroup %s;", indexes[0])
+	return err
 }
 
 /*
@@ -211,9 +237,34 @@ WriteBlocks writes out the block definitions and their names.
 
 There is an implicit block `[0 "ALL"`, which cannot be renamed,
 so the first user defined block is `1`.
-*/
 
+TNT won't take a single block; so if there is only one gene, it is
+written out as the first xgroup instead.
+
+	xgroup
+	=0 (ATP8) 0.18
+	;
+
+Any character groups are written out as xgroups after the blocks (or
+the single gene).
+*/
 func (t *TNT) WriteBlocks(writer io.Writer) error {
+	groups := append([]CharacterGroup{}, t.CharacterGroups...)
+	if t.CodonGroups {
+		groups = append(groups, t.codonGroups(t.geneRanges())...)
+	}
+	if len(t.MetaData) == 1 {
+		gene := CharacterGroup{Name: t.MetaData[0].Gene, Genes: []string{t.MetaData[0].Gene}}
+		return t.WriteXGroups(writer, append([]CharacterGroup{gene}, groups...))
+	}
+	if err := t.writeBlocks(writer); err != nil {
+		return err
+	}
+	return t.WriteXGroups(writer, groups)
+}
+
+// writeBlocks writes out the blocks and cnames for more than one gene
+func (t *TNT) writeBlocks(writer io.Writer) error {
 	var startPos []string = make([]string, 0, len(t.MetaData))
 	var cnames []string = make([]string, 0, len(t.MetaData))
 
@@ -241,12 +292,9 @@ func (t *TNT) WriteBlocks(writer io.Writer) error {
 // WriteSequences will collect up the sequences, verify their validity,
 // and output a formated TNT file to the supplied writer
 func (t *TNT) WriteSequences(writer io.Writer) error {
-	if _, err := t.GenerateMetaData(); err != nil {
+	if err := t.prepare(); err != nil {
 		return err
 	}
-	if t.dirtyData {
-		t.CleanData()
-	}
 
 	if err := t.WriteNState(writer); err != nil {
 		return err
@@ -256,134 +304,17 @@ func (t *TNT) WriteSequences(writer io.Writer) error {
 		return err
 	}
 
-	if err := t.WriteBlocks(writer); err != nil {
+	if err := t.WriteCCode(writer); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func geneLength(lengths map[int][]string) (max int) {
-	for i, _ := range lengths {
-		if i > max {
-			max = i
-		}
-	}
-	return
-}
-
-// fmtInvalidSequenceErr will return a specialized error for invalid
-// sequence lengths.
-func fmtInvalidSequenceErr(sequenceName string, lengths map[int][]string) error {
-	details := []string{}
-	for length, seqs := range lengths {
-		details = append(details, fmt.Sprintf("\t%d: %s", length, strings.Join(seqs, ", ")))
-	}
-
-	detailedMessage := fmt.Sprintf("Sequence %s has inconsistant sequence lengths:\n%s", sequenceName, strings.Join(details, "\n"))
-	return sequence.InvalidSequence{
-		Message: "Sequences are not the Same length",
-		Details: detailedMessage,
-		Errno:   sequence.MISSMATCHED_SEQUENCE_LENGTHS,
-	}
-}
-
-/*
-GenerateMetaData will make sure that the sequences for the same
-gene sequence (or whatever sequence) are all the same length.
-Returns types of InvalidSequence with ErrNo
-MISSMATCHED_SEQUENCE_LENGTHS if they are no correct
-If they are correct, it will return a slice of the gene meta data
-GeneMetaData, sequence.GMDSlice
-
-If a sequence is zero; it is not counted as bad.  It  needs to be
-cleaned up with a call to CleanData
-
-This will also set the max lenght sequence size; which is used
-by some helper functions
-*/
-func (t *TNT) GenerateMetaData() (sequence.GMDSlice, error) {
-	geneMetaData := make(sequence.GMDSlice, 0, len(t.Sequences))
-
-	for gene, _ := range t.Sequences {
-		lengths := make(map[int][]string)
-		for _, name := range t.speciesNames {
-			seq := t.Sequences[gene][name]
-			if seq.Length > t.maxSequenceLength {
-				t.maxSequenceLength = seq.Length
-			}
-			if _, ok := lengths[seq.Length]; ok {
-				lengths[seq.Length] = append(lengths[seq.Length], seq.Name)
-			} else {
-				lengths[seq.Length] = []string{seq.Name}
-			}
-		}
-		_, hasZero := lengths[0]
-		if (len(lengths) > 2) || (len(lengths) > 1 && !hasZero) {
-			return nil, fmtInvalidSequenceErr(gene, lengths)
-		}
-
-		if hasZero {
-			t.dirtyData = true
-		}
-		geneMetaData = append(geneMetaData, sequence.GeneMetaData{
-			Gene:          gene,
-			Length:        geneLength(lengths),
-			NumberSpecies: len(t.Sequences[gene]),
-		})
+	if err := t.WriteOutgroup(writer); err != nil {
+		return err
 	}
-	t.MetaData = geneMetaData
-	geneMetaData.Sort()
-	return geneMetaData, nil
-}
 
-// getTotalLength will return the combined length of all genes.  This should
-// be the same for each species.
-func (t *TNT) getTotalLength() (length int) {
-	for _, gmd := range t.MetaData {
-		length = length + gmd.Length
+	if err := t.WriteBlocks(writer); err != nil {
+		return err
 	}
-	return
-}
 
-// SetOutgroup will set the outgroup under test.  This sorts it to the top
-// of the list of taxa in the xread block
-func (t *TNT) SetOutgroup(species string) error {
-	t.Outgroup = species
 	return nil
 }
-
-/*
-blankSequence returns a slice of '---' bytes.  Does this by pre-allocating
-
-the longest that could be returned, and slicing up subsets of it to be returned.
-this should be fine because once returned, they should never be modified,
-so the shared memory should not be a problem
-*/
-func (t *TNT) blankSequence(n int) sequence.SequenceData {
-	if t.blankSeq == nil || len(t.blankSeq) < t.maxSequenceLength {
-		t.blankSeq = make(sequence.SequenceData, t.maxSequenceLength, t.maxSequenceLength)
-		for i, _ := range t.blankSeq {
-			t.blankSeq[i] = '-'
-		}
-	}
-
-	return t.blankSeq[:n]
-}
-
-/*
-CleanData will fill in missing data.
-*/
-func (t *TNT) CleanData() {
-	for _, gmd := range t.MetaData {
-		for _, name := range t.speciesNames {
-			seq := t.Sequences[gmd.Gene][name]
-			if len(seq.Seq) == 0 {
-				seq.Seq = t.blankSequence(gmd.Length)
-				seq.Length = gmd.Length
-				t.Sequences[gmd.Gene][name] = seq
-			}
-		}
-	}
-
-}
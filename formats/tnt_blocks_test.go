@@ -52,3 +52,92 @@ cnames
 	}
 
 }
+
+func TestWriteBlocksWithCharacterGroups(t *testing.T) {
+	tnt := &formats.TNT{CodonGroups: true}
+	tnt.AddSequence(phylipTestSequences()...)
+	group, err := formats.ParseCharacterGroup("mito=ATP8,1-2")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tnt.AddCharacterGroup(group)
+	if _, err := tnt.GenerateMetaData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := tnt.WriteBlocks(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := `
+blocks 0 11;
+cnames
+[1 ATP6;
+[2 ATP8;
+;
+xgroup
+=0 (mito) 0.1 11.18
+=1 (first) 0 3 6 9 11 14 17
+=2 (second) 1 4 7 10 12 15 18
+=3 (third) 2 5 8 13 16
+;`
+	got := buf.String()
+	if got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+}
+
+func TestWriteBlocksSingleGeneIsXGroup(t *testing.T) {
+	sequence1 := sequence.NewSequence("Homo sapiens", []byte("ATAGCTACG"))
+	sequence1.Species = "Homo sapiens"
+	sequence1.Gene = "ATP8"
+
+	tnt := &formats.TNT{}
+	tnt.AddSequence(sequence1)
+	tnt.AddCharacterGroup(formats.CharacterGroup{Name: "start", Characters: []int{0, 1, 2}})
+	if _, err := tnt.GenerateMetaData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := tnt.WriteBlocks(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := `
+xgroup
+=0 (ATP8) 0.8
+=1 (start) 0.2
+;`
+	got := buf.String()
+	if got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+
+	read := &formats.TNT{}
+	buf = bytes.Buffer{}
+	tnt.WriteSequences(&buf)
+	if err := read.Parse(&buf, "default"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := read.Sequences["ATP8"]; !ok {
+		t.Errorf("Expected the xgroup to be read back as the gene ATP8, got %v", read.Sequences)
+	}
+	if len(read.CharacterGroups) != 1 || read.CharacterGroups[0].Name != "start" {
+		t.Errorf("Expected the start group to be kept, got %v", read.CharacterGroups)
+	}
+}
+
+func TestWriteBlocksUnknownGeneInGroup(t *testing.T) {
+	tnt := &formats.TNT{}
+	tnt.AddSequence(phylipTestSequences()...)
+	tnt.AddCharacterGroup(formats.CharacterGroup{Name: "mito", Genes: []string{"ATP9"}})
+	if _, err := tnt.GenerateMetaData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := tnt.WriteBlocks(&bytes.Buffer{}); err == nil {
+		t.Errorf("Expected an error for an unknown gene, got <nil>")
+	}
+}
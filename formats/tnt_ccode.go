@@ -0,0 +1,210 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// CCodeSetting is a character setting which can be turned on or off, or
+// left as it is
+type CCodeSetting int
+
+const (
+	CCODE_UNCHANGED CCodeSetting = iota
+	CCODE_ON
+	CCODE_OFF
+)
+
+/*
+CharacterCode is the TNT ccode for a set of characters; whether they are
+active, additive and how much they are weighted.  Settings which are left
+unchanged keep what an earlier CharacterCode set, or the TNT default
+(active, nonadditive, with a weight of 1).
+*/
+type CharacterCode struct {
+	Characters CharacterGroup
+	Active     CCodeSetting
+	Additive   CCodeSetting
+	// Weight of the characters; 0 leaves it unchanged
+	Weight int
+}
+
+// TNT_MAX_WEIGHT is the largest weight TNT will take for a character
+const TNT_MAX_WEIGHT = 1000
+
+// codes returns the ccode specifiers; `+]/3`
+func (c CharacterCode) codes() string {
+	codes := ""
+	switch c.Additive {
+	case CCODE_ON:
+		codes += "+"
+	case CCODE_OFF:
+		codes += "-"
+	}
+	switch c.Active {
+	case CCODE_ON:
+		codes += "["
+	case CCODE_OFF:
+		codes += "]"
+	}
+	if c.Weight > 0 {
+		codes += "/" + strconv.Itoa(c.Weight)
+	}
+	return codes
+}
+
+func characterCodeError(details string, args ...interface{}) error {
+	return sequence.FormatError{
+		Message: "Badly formated character code",
+		Details: fmt.Sprintf(details, args...),
+		Errno:   sequence.BAD_FORMAT,
+	}
+}
+
+/*
+ParseCharacterCode reads a character code; the settings, followed by a
+comma separated list of genes and (one indexed) character ranges
+
+	inactive ATP6
+	additive weight=3 1-20,ATP8
+
+The settings are active, inactive, additive, nonadditive and weight=N; or
+the TNT ccode specifiers `[`, `]`, `+`, `-` and `/N`, which can be run
+together; `+]/3`.
+*/
+func ParseCharacterCode(spec string) (CharacterCode, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return CharacterCode{}, characterCodeError("expected SETTINGS GENE,START-END,... got '%s'", spec)
+	}
+	characters, err := parseCharacterList("ccode", fields[len(fields)-1])
+	if err != nil {
+		return CharacterCode{}, err
+	}
+	code := CharacterCode{Characters: characters}
+	for _, setting := range fields[:len(fields)-1] {
+		switch {
+		case setting == "active":
+			code.Active = CCODE_ON
+		case setting == "inactive":
+			code.Active = CCODE_OFF
+		case setting == "additive":
+			code.Additive = CCODE_ON
+		case setting == "nonadditive":
+			code.Additive = CCODE_OFF
+		case strings.HasPrefix(setting, "weight="):
+			if err := code.setWeight(strings.TrimPrefix(setting, "weight=")); err != nil {
+				return CharacterCode{}, err
+			}
+		default:
+			if err := code.setCodes(setting); err != nil {
+				return CharacterCode{}, characterCodeError("unknown setting '%s' in '%s'", setting, spec)
+			}
+		}
+	}
+	return code, nil
+}
+
+func (c *CharacterCode) setWeight(weight string) error {
+	var err error
+	if c.Weight, err = strconv.Atoi(weight); err != nil || c.Weight < 1 || c.Weight > TNT_MAX_WEIGHT {
+		return characterCodeError("weights must be a number from 1 to %d, got '%s'", TNT_MAX_WEIGHT, weight)
+	}
+	return nil
+}
+
+// setCodes reads the TNT ccode specifiers; eg `+]/3`
+func (c *CharacterCode) setCodes(codes string) error {
+	for i := 0; i < len(codes); i++ {
+		switch codes[i] {
+		case '[':
+			c.Active = CCODE_ON
+		case ']':
+			c.Active = CCODE_OFF
+		case '+':
+			c.Additive = CCODE_ON
+		case '-':
+			c.Additive = CCODE_OFF
+		case '/':
+			end := i + 1
+			for end < len(codes) && codes[end] >= '0' && codes[end] <= '9' {
+				end++
+			}
+			if err := c.setWeight(codes[i+1 : end]); err != nil {
+				return err
+			}
+			i = end - 1
+		default:
+			return fmt.Errorf("unknown ccode '%c'", codes[i])
+		}
+	}
+	return nil
+}
+
+/*
+ReadCharacterCodes reads a file of character codes, one per line in the
+same form as ParseCharacterCode.  Blank lines, and anything after a `#`,
+are ignored.
+
+	# the third positions are saturated
+	inactive 3-658\3
+	weight=2 ATP8
+*/
+func ReadCharacterCodes(input io.Reader) ([]CharacterCode, error) {
+	codes := []CharacterCode{}
+	lines := bufio.NewScanner(input)
+	for number := 1; lines.Scan(); number++ {
+		line := lines.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		code, err := ParseCharacterCode(line)
+		if err != nil {
+			if e, ok := err.(sequence.FormatError); ok {
+				e.Details = fmt.Sprintf("line %d: %s", number, e.Details)
+				err = e
+			}
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, lines.Err()
+}
+
+// AddCharacterCode (or multiple) to be written out as ccode commands.
+// They are written in the order they are added; so later codes override
+// the earlier ones.
+func (t *TNT) AddCharacterCode(codes ...CharacterCode) {
+	t.CharacterCodes = append(t.CharacterCodes, codes...)
+}
+
+/*
+WriteCCode writes out a ccode command for each of the character codes
+
+	ccode ] 0.10;
+	ccode +/3 11.18;
+*/
+func (t *TNT) WriteCCode(writer io.Writer) error {
+	ranges := t.geneRanges()
+	for _, code := range t.CharacterCodes {
+		characters, err := t.characters(code.Characters, ranges)
+		if err != nil {
+			return err
+		}
+		if len(characters) == 0 || code.codes() == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(writer, "\nccode %s %s;", code.codes(), characterList(characters)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func TestParseCharacterCodeSettings(t *testing.T) {
+	code, err := formats.ParseCharacterCode("inactive additive weight=3 ATP8,1-6\\3")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if code.Active != formats.CCODE_OFF || code.Additive != formats.CCODE_ON || code.Weight != 3 {
+		t.Errorf("Expected inactive, additive with a weight of 3, got %+v", code)
+	}
+	if len(code.Characters.Genes) != 1 || code.Characters.Genes[0] != "ATP8" {
+		t.Errorf("Expected the gene ATP8, got %v", code.Characters.Genes)
+	}
+	if len(code.Characters.Characters) != 2 || code.Characters.Characters[0] != 0 || code.Characters.Characters[1] != 3 {
+		t.Errorf("Expected the characters [0 3], got %v", code.Characters.Characters)
+	}
+}
+
+func TestParseCharacterCodeBadWeight(t *testing.T) {
+	for _, spec := range []string{"weight=0 ATP8", "/1001 ATP8", "heavy ATP8", "ATP8"} {
+		if _, err := formats.ParseCharacterCode(spec); err == nil {
+			t.Errorf("Expected an error for '%s', got <nil>", spec)
+		} else if err.(sequence.FormatError).Errno != sequence.BAD_FORMAT {
+			t.Errorf("Expected a BAD_FORMAT error for '%s', got %v", spec, err)
+		}
+	}
+}
+
+func TestReadCharacterCodesReportsLine(t *testing.T) {
+	input := `# comments and blank lines are skipped
+
+] ATP6
+weight=two ATP8
+`
+	_, err := formats.ReadCharacterCodes(bytes.NewBufferString(input))
+	if err == nil {
+		t.Fatalf("Expected an error, got <nil>")
+	}
+	if details := err.(sequence.FormatError).Details; details[:7] != "line 4:" {
+		t.Errorf("Expected the error to be on line 4, got '%s'", details)
+	}
+}
+
+func TestWriteSequencesWithCharacterCodes(t *testing.T) {
+	codes, err := formats.ReadCharacterCodes(bytes.NewBufferString("] ATP6 # not this one\n+/2 12-19\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tnt := &formats.TNT{Title: "Title Here"}
+	tnt.AddSequence(phylipTestSequences()...)
+	tnt.AddCharacterCode(codes...)
+
+	buf := bytes.Buffer{}
+	if err := tnt.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := `nstates DNA;
+xread
+'Title Here'
+19 2
+Homo_sapiens TAGCATAGCTGATAGCT[AG]G
+Homo_sapiens_neanderthalensis TAGCATAGCTAATAGCTAC
+;
+ccode ] 0.10;
+ccode +/2 11.18;
+blocks 0 11;
+cnames
+[1 ATP6;
+[2 ATP8;
+;`
+	got := buf.String()
+	if got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+}
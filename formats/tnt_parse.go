@@ -0,0 +1,518 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yarbelk/refasta/scanner"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// tntParser holds the state needed while reading through a TNT file
+type tntParser struct {
+	words       *wordScanner
+	title       string
+	ntax, nchar int
+	rows        []*matrixRow
+	rowIndex    map[string]int
+	blocks      []int
+	blockNames  map[int]string
+	outgroups   []int
+	xgroups     []CharacterGroup
+}
+
+func (p *tntParser) formatError(details string, args ...interface{}) error {
+	line, column := p.words.Position()
+	return sequence.FormatError{
+		Message: "Badly formated TNT file",
+		Details: fmt.Sprintf(details, args...),
+		Errno:   sequence.BAD_FORMAT,
+		Line:    line,
+		Column:  column,
+	}
+}
+
+/*
+Parse will read a TNT file, such as the ones written by WriteSequences, and
+add the sequences in it.  It understands the xread (both a single block, and
+interleaved `&[dna]` sections), blocks, cnames, xgroup and outgroup
+commands (and the agroup named outgroup); every other command is skipped.
+
+The matrix is split up into a sequence per gene using the blocks, with the
+cnames as the gene names.  If there are no blocks, the xgroups that split
+the matrix up into contiguous parts are used (a single gene is written as
+an xgroup); otherwise the whole matrix is put in a gene called geneName.
+The rest of the xgroups are kept as CharacterGroups, with their characters
+by gene; so they are still right if the genes are written out in another
+order, or with other genes.
+*/
+func (t *TNT) Parse(input io.Reader, geneName ...string) error {
+	var gene string
+	if len(geneName) == 1 {
+		gene = geneName[0]
+	}
+	p := &tntParser{
+		words:      newWordScanner(input, ";", false),
+		rowIndex:   make(map[string]int),
+		blockNames: make(map[int]string),
+	}
+
+	for {
+		word, _, err := p.words.Word()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p.formatError("%s", err.Error())
+		}
+		switch strings.ToLower(word) {
+		case ";":
+			continue
+		case "xread":
+			err = p.parseXRead()
+		case "blocks":
+			err = p.parseBlocks()
+		case "cnames":
+			err = p.parseCNames()
+		case "xgroup":
+			err = p.parseXGroup()
+		case "outgroup":
+			err = p.parseOutgroup()
+		case "agroup":
+			err = p.parseAGroup()
+		default:
+			// nstates, proc etc; nothing we need from them
+			_, err = p.words.Command()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(p.rows) == 0 {
+		return p.formatError("No xread command with any taxa")
+	}
+	if err := p.checkBlocks(); err != nil {
+		return err
+	}
+	t.Title = p.title
+	outgroups, err := p.outgroupNames()
+	if err != nil {
+		return err
+	}
+	partitions := p.partitions(gene)
+	t.AddSequence(splitRows(p.rows, partitions)...)
+	t.AddCharacterGroup(geneCharacterGroups(p.xgroups, partitions)...)
+	return t.SetOutgroup(outgroups...)
+}
+
+/*
+parseXRead reads
+
+	xread
+	'optional title'
+	NCHAR NTAX
+	taxon_1 ACGT...
+	;
+
+The data can also be split up into sections starting with `&[dna]` (or
+prot, num etc), with each section having a line per taxon.
+*/
+func (p *tntParser) parseXRead() error {
+	ch, err := p.words.peek()
+	if err != nil {
+		return p.formatError("xread is missing its data")
+	}
+	if ch == '\'' {
+		if p.title, _, err = p.words.Word(); err != nil {
+			return p.formatError("%s", err.Error())
+		}
+	}
+	for _, dimension := range []*int{&p.nchar, &p.ntax} {
+		word, _, err := p.words.Word()
+		if err != nil {
+			return p.formatError("xread is missing its dimensions")
+		}
+		if *dimension, err = strconv.Atoi(word); err != nil {
+			return p.formatError("xread dimensions must be numbers, got '%s'", word)
+		}
+	}
+
+	interleaved := false
+	for {
+		ch, err := p.words.peek()
+		if err != nil {
+			return p.formatError("xread is missing its closing ';'")
+		}
+		if ch == ';' {
+			p.words.read()
+			break
+		}
+		if ch == '&' {
+			// &[dna] etc; the type is worked out from the data itself
+			interleaved = true
+			for ch != ']' {
+				if ch, err = p.words.read(); err != nil {
+					return p.formatError("Unbalanced & [] in xread")
+				}
+			}
+			continue
+		}
+		name, _, err := p.words.Word()
+		if err != nil {
+			return p.formatError("%s", err.Error())
+		}
+		// TNT names can't have spaces; so they are written with underscores
+		name = strings.Replace(name, "_", " ", -1)
+		index, ok := p.rowIndex[name]
+		if !ok {
+			index = len(p.rows)
+			p.rowIndex[name] = index
+			p.rows = append(p.rows, &matrixRow{name: name})
+		}
+		if err := p.readData(p.rows[index], interleaved); err != nil {
+			return err
+		}
+	}
+	return p.checkMatrix()
+}
+
+// readData reads the characters for a row.  If the xread is interleaved it
+// stops at the end of the line; otherwise when it has NCHAR characters.
+func (p *tntParser) readData(row *matrixRow, interleaved bool) error {
+	for interleaved || row.length < p.nchar {
+		ch, err := p.words.read()
+		switch {
+		case err != nil:
+			return nil
+		case ch == '\n' && interleaved:
+			return nil
+		case scanner.IsWhitespace(ch):
+			continue
+		case ch == ';' || ch == '&':
+			p.words.unread()
+			return nil
+		case ch == '[':
+			group, err := p.readPolymorphism()
+			if err != nil {
+				return err
+			}
+			row.data = append(row.data, group...)
+			row.length++
+		case ch < 0x80 && scanner.IsSequenceData(ch):
+			row.data = append(row.data, byte(ch))
+			row.length++
+		default:
+			return p.formatError("Unexpected '%c' in the data for '%s'", ch, row.name)
+		}
+	}
+	return nil
+}
+
+// readPolymorphism reads a `[AG]` group, which has already had its opening
+// bracket read
+func (p *tntParser) readPolymorphism() ([]byte, error) {
+	group := []byte{'['}
+	for {
+		ch, err := p.words.read()
+		switch {
+		case err != nil:
+			return nil, p.formatError("Unbalanced [] in the data")
+		case ch == ']':
+			return append(group, ']'), nil
+		case scanner.IsWhitespace(ch):
+			continue
+		case ch < 0x80 && scanner.IsSequenceData(ch):
+			group = append(group, byte(ch))
+		default:
+			return nil, p.formatError("Unexpected '%c' in a polymorphism", ch)
+		}
+	}
+}
+
+// checkMatrix makes sure we got the number of taxa and characters that
+// were promised in the xread dimensions
+func (p *tntParser) checkMatrix() error {
+	if p.ntax != len(p.rows) {
+		return p.formatError("xread has %d taxa, but should have %d", len(p.rows), p.ntax)
+	}
+	bad := []string{}
+	for _, row := range p.rows {
+		if row.length != p.nchar {
+			bad = append(bad, fmt.Sprintf("\t%s: %d", row.name, row.length))
+		}
+	}
+	if len(bad) > 0 {
+		return p.formatError("xread has %d characters, but these taxa have a different number:\n%s", p.nchar, strings.Join(bad, "\n"))
+	}
+	return nil
+}
+
+// parseBlocks reads the starting character of each block; `blocks 0 11;`
+func (p *tntParser) parseBlocks() error {
+	words, err := p.words.Command()
+	if err != nil {
+		return p.formatError("blocks is missing its closing ';'")
+	}
+	p.blocks = make([]int, 0, len(words))
+	for _, word := range words {
+		start, err := strconv.Atoi(word)
+		if err != nil {
+			return p.formatError("blocks must be character numbers, got '%s'", word)
+		}
+		p.blocks = append(p.blocks, start)
+	}
+	return nil
+}
+
+/*
+parseCNames reads the block names (and skips the character names)
+
+	cnames
+	[1 ATP6;
+	{0 first_character;
+	;
+*/
+func (p *tntParser) parseCNames() error {
+	for {
+		words, err := p.words.Command()
+		if err != nil {
+			return p.formatError("cnames is missing its closing ';'")
+		}
+		if len(words) == 0 {
+			return nil
+		}
+		if !strings.HasPrefix(words[0], "[") {
+			continue
+		}
+		number := strings.TrimPrefix(words[0], "[")
+		if number == "" && len(words) > 1 {
+			number, words = words[1], words[1:]
+		}
+		block, err := strconv.Atoi(number)
+		if err != nil || len(words) < 2 {
+			return p.formatError("Expected '[N name;' in cnames, got '%s'", strings.Join(words, " "))
+		}
+		p.blockNames[block] = strings.Join(words[1:], " ")
+	}
+}
+
+// parseOutgroup reads the taxon number of the outgroup; `outgroup 0;`
+func (p *tntParser) parseOutgroup() error {
+	words, err := p.words.Command()
+	if err != nil {
+		return p.formatError("outgroup is missing its closing ';'")
+	}
+	if len(words) != 1 {
+		return p.formatError("outgroup must be a single taxon number, got '%s'", strings.Join(words, " "))
+	}
+	index, err := strconv.Atoi(words[0])
+	if err != nil {
+		return p.formatError("outgroup must be a taxon number, got '%s'", words[0])
+	}
+	// the outgroup agroup has already said which taxa are outgroups
+	if len(p.outgroups) == 0 || p.outgroups[0] != index {
+		p.outgroups = []int{index}
+	}
+	return nil
+}
+
+// parseAGroup reads the taxon group named outgroup, which is how more than
+// one outgroup is written; `agroup =0 (outgroup) 0 1;`.  The other groups
+// are skipped.
+func (p *tntParser) parseAGroup() error {
+	words, err := p.words.Command()
+	if err != nil {
+		return p.formatError("agroup is missing its closing ';'")
+	}
+	if len(words) < 3 || words[1] != "(outgroup)" {
+		return nil
+	}
+	p.outgroups = make([]int, 0, len(words)-2)
+	for _, word := range words[2:] {
+		index, err := strconv.Atoi(word)
+		if err != nil {
+			return p.formatError("agroup must be taxon numbers, got '%s'", word)
+		}
+		p.outgroups = append(p.outgroups, index)
+	}
+	return nil
+}
+
+// outgroupNames returns the names of the outgroup taxa
+func (p *tntParser) outgroupNames() ([]string, error) {
+	names := make([]string, 0, len(p.outgroups))
+	for _, index := range p.outgroups {
+		if index < 0 || index >= len(p.rows) {
+			return nil, p.formatError("outgroup %d isn't one of the %d taxa", index, len(p.rows))
+		}
+		names = append(names, p.rows[index].name)
+	}
+	return names, nil
+}
+
+// checkBlocks makes sure the blocks start at the first character, and are
+// in order
+func (p *tntParser) checkBlocks() error {
+	for i, start := range p.blocks {
+		if (i == 0 && start != 0) || (i > 0 && start <= p.blocks[i-1]) || start >= p.nchar {
+			return p.formatError("blocks must start at 0, and be in order within the %d characters", p.nchar)
+		}
+	}
+	return nil
+}
+
+/*
+parseXGroup reads the character groups; the characters are listed as
+single characters, or ranges
+
+	xgroup
+	=0 (ATP8) 0.18
+	=1 (first) 0 3 6.8
+	;
+*/
+func (p *tntParser) parseXGroup() error {
+	words, err := p.words.Command()
+	if err != nil {
+		return p.formatError("xgroup is missing its closing ';'")
+	}
+	var group *CharacterGroup
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case strings.HasPrefix(word, "="):
+			if word == "=" && i+1 < len(words) {
+				i++
+			}
+			p.xgroups = append(p.xgroups, CharacterGroup{})
+			group = &p.xgroups[len(p.xgroups)-1]
+		case group == nil:
+			return p.formatError("xgroup must start with '=N', got '%s'", word)
+		case strings.HasPrefix(word, "("):
+			name := []string{word}
+			for !strings.HasSuffix(name[len(name)-1], ")") && i+1 < len(words) {
+				i++
+				name = append(name, words[i])
+			}
+			group.Name = strings.Trim(strings.Join(name, " "), "()")
+		default:
+			bounds := strings.SplitN(word, ".", 2)
+			start, err := strconv.Atoi(bounds[0])
+			end := start
+			if err == nil && len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+			}
+			if err != nil || end < start {
+				return p.formatError("xgroup characters must be numbers or ranges, got '%s'", word)
+			}
+			for c := start; c <= end; c++ {
+				group.Characters = append(group.Characters, c)
+			}
+		}
+	}
+	return nil
+}
+
+// contiguousXGroup returns the index of the xgroup which is exactly the
+// characters from start up to some later character, if there is one
+func (p *tntParser) contiguousXGroup(start int) (int, bool) {
+	for i, group := range p.xgroups {
+		chars := group.Characters
+		if len(chars) > 0 && chars[0] == start && chars[len(chars)-1] == start+len(chars)-1 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// xgroupPartitions turns the xgroups which split the matrix up into
+// contiguous parts into charSets, and removes them from the xgroups.  If
+// the matrix can't be split up this way, it returns nil.
+func (p *tntParser) xgroupPartitions() []charSet {
+	partitions := []charSet{}
+	used := make(map[int]bool)
+	for start := 0; start < p.nchar; {
+		i, ok := p.contiguousXGroup(start)
+		if !ok {
+			return nil
+		}
+		group := p.xgroups[i]
+		end := start + len(group.Characters)
+		partitions = append(partitions, contiguousCharSet(group.Name, start, end))
+		used[i] = true
+		start = end
+	}
+	xgroups := make([]CharacterGroup, 0, len(p.xgroups))
+	for i, group := range p.xgroups {
+		if !used[i] {
+			xgroups = append(xgroups, group)
+		}
+	}
+	p.xgroups = xgroups
+	return partitions
+}
+
+// geneCharacterGroups turns the characters of the groups into positions
+// within the (contiguous) partitions they are in; a group with all of a
+// partition has the whole gene
+func geneCharacterGroups(groups []CharacterGroup, partitions []charSet) []CharacterGroup {
+	byGene := make([]CharacterGroup, 0, len(groups))
+	for _, group := range groups {
+		positions := make(map[string][]int)
+		for _, c := range group.Characters {
+			for _, partition := range partitions {
+				if len(partition.positions) == 0 {
+					continue
+				}
+				start := partition.positions[0]
+				if c >= start && c < start+len(partition.positions) {
+					positions[partition.name] = append(positions[partition.name], c-start)
+					break
+				}
+			}
+		}
+		geneGroup := CharacterGroup{Name: group.Name}
+		for _, partition := range partitions {
+			chars, ok := positions[partition.name]
+			if !ok {
+				continue
+			}
+			if len(chars) == len(partition.positions) {
+				geneGroup.Genes = append(geneGroup.Genes, partition.name)
+				continue
+			}
+			if geneGroup.GeneCharacters == nil {
+				geneGroup.GeneCharacters = make(map[string][]int)
+			}
+			geneGroup.GeneCharacters[partition.name] = chars
+		}
+		byGene = append(byGene, geneGroup)
+	}
+	return byGene
+}
+
+// partitions turns the blocks into charSets.  Block 0 is all of the
+// characters; so the user defined ones start at 1.
+func (p *tntParser) partitions(gene string) []charSet {
+	if len(p.blocks) == 0 {
+		if partitions := p.xgroupPartitions(); len(partitions) > 0 {
+			return partitions
+		}
+		return []charSet{contiguousCharSet(gene, 0, p.nchar)}
+	}
+	partitions := make([]charSet, 0, len(p.blocks))
+	for i, start := range p.blocks {
+		end := p.nchar
+		if i+1 < len(p.blocks) {
+			end = p.blocks[i+1]
+		}
+		name, ok := p.blockNames[i+1]
+		if !ok {
+			name = fmt.Sprintf("%s_%d", gene, i+1)
+		}
+		partitions = append(partitions, contiguousCharSet(name, start, end))
+	}
+	return partitions
+}
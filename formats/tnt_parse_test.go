@@ -0,0 +1,202 @@
+package formats_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func tntSequencesByKey(tnt *formats.TNT) map[string]sequence.Sequence {
+	seqs := make(map[string]sequence.Sequence)
+	for _, seq := range tnt.AllSequences() {
+		seqs[seq.Gene+"/"+seq.Species] = seq
+	}
+	return seqs
+}
+
+func TestTNTParseSplitsByBlocks(t *testing.T) {
+	input := `nstates DNA;
+xread
+'Title Here'
+19 2
+Homo_erectus TAGCATAGCTAATAGCTAC
+Homo_sapiens TAGCATAGCT[GA]ATAGCTAG
+;
+blocks 0 11;
+cnames
+[1 ATP6;
+[2 ATP8;
+;
+proc /;`
+
+	tnt := &formats.TNT{}
+	if err := tnt.Parse(bytes.NewBufferString(input), "mito"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if tnt.Title != "Title Here" {
+		t.Errorf("Expected the title to be 'Title Here', got '%s'", tnt.Title)
+	}
+
+	expected := map[string]string{
+		"ATP6/Homo erectus": "TAGCATAGCTA",
+		"ATP8/Homo erectus": "ATAGCTAC",
+		"ATP6/Homo sapiens": "TAGCATAGCT[GA]",
+		"ATP8/Homo sapiens": "ATAGCTAG",
+	}
+	got := tntSequencesByKey(tnt)
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d sequences, got %d: %v", len(expected), len(got), got)
+	}
+	for key, data := range expected {
+		if string(got[key].Seq) != data {
+			t.Errorf("Expected %s to be '%s', got '%s'", key, data, got[key].Seq)
+		}
+	}
+}
+
+func TestTNTParseInterleavedSections(t *testing.T) {
+	input := `xread
+12 2
+&[dna]
+A_a ATAGCTAC
+B_b ATAGCTAG
+&[dna gaps]
+A_a ACGT
+B_b AC-T
+;`
+
+	tnt := &formats.TNT{}
+	if err := tnt.Parse(bytes.NewBufferString(input), "co1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]string{
+		"co1/A a": "ATAGCTACACGT",
+		"co1/B b": "ATAGCTAGAC-T",
+	}
+	got := tntSequencesByKey(tnt)
+	for key, data := range expected {
+		if string(got[key].Seq) != data {
+			t.Errorf("Expected %s to be '%s', got '%s'", key, data, got[key].Seq)
+		}
+	}
+}
+
+func TestTNTParseWrongDimensionsIsFormatError(t *testing.T) {
+	input := `xread
+9 2
+A_a ATAGCTAGC
+B_b ATAGCTAC
+;`
+
+	tnt := &formats.TNT{}
+	err := tnt.Parse(bytes.NewBufferString(input))
+
+	if err == nil {
+		t.Fatalf("Expected error to be; error was <nil>")
+	}
+
+	if err.(sequence.FormatError).Errno != sequence.BAD_FORMAT {
+		t.Errorf("Expected Errno to be '%d', was '%d'",
+			sequence.BAD_FORMAT,
+			err.(sequence.FormatError).Errno)
+	}
+}
+
+func TestTNTRoundTrip(t *testing.T) {
+	sequence1 := sequence.NewSequence("Homo sapiens", []byte("ATAGCTAG"))
+	sequence1.Species = "Homo sapiens"
+	sequence1.Gene = "ATP8"
+
+	sequence2 := sequence.NewSequence("Homo erectus", []byte("ATAGCTAC"))
+	sequence2.Species = "Homo erectus"
+	sequence2.Gene = "ATP8"
+
+	sequence3 := sequence.NewSequence("Homo sapiens", []byte("TAGCATAGCTG"))
+	sequence3.Species = "Homo sapiens"
+	sequence3.Gene = "ATP6"
+
+	sequence4 := sequence.NewSequence("Homo erectus", []byte("TAGCATAGCTA"))
+	sequence4.Species = "Homo erectus"
+	sequence4.Gene = "ATP6"
+
+	written := &formats.TNT{Title: "Title Here"}
+	written.AddSequence(sequence1, sequence2, sequence3, sequence4)
+	buf := bytes.Buffer{}
+	if err := written.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	read := &formats.TNT{}
+	if err := read.Parse(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got := tntSequencesByKey(read)
+	for _, seq := range []sequence.Sequence{sequence1, sequence2, sequence3, sequence4} {
+		if string(got[seq.Gene+"/"+seq.Species].Seq) != string(seq.Seq) {
+			t.Errorf("Expected %s/%s to round trip as '%s', got '%s'", seq.Gene, seq.Species, seq.Seq, got[seq.Gene+"/"+seq.Species].Seq)
+		}
+	}
+}
+
+func TestTNTParseKeepsGroupsWithTheirGenes(t *testing.T) {
+	input := `xread
+19 3
+Homo_erectus TAGCATAGCTAATAGCTAC
+Homo_sapiens TAGCATAGCTGATAGCTAG
+Pan_paniscus TAGCATAGCTAATAGCTCC
+;
+blocks 0 11;
+cnames
+[1 ATP6;
+[2 ATP8;
+;
+xgroup
+=0 (start) 0.2 11.13
+=1 (mito) 0.18
+;
+agroup =0 (outgroup) 2 0;
+outgroup 2;`
+
+	tnt := &formats.TNT{}
+	if err := tnt.Parse(bytes.NewBufferString(input)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []formats.CharacterGroup{
+		{Name: "start", GeneCharacters: map[string][]int{"ATP6": {0, 1, 2}, "ATP8": {0, 1, 2}}},
+		{Name: "mito", Genes: []string{"ATP6", "ATP8"}},
+	}
+	if !reflect.DeepEqual(tnt.CharacterGroups, expected) {
+		t.Errorf("Expected the groups by gene %v, got %v", expected, tnt.CharacterGroups)
+	}
+	if !reflect.DeepEqual(tnt.Outgroups, []string{"Pan paniscus", "Homo erectus"}) {
+		t.Errorf("Expected the outgroups [Pan paniscus Homo erectus], got %v", tnt.Outgroups)
+	}
+
+	// A gene sorted before the others moves them along; the groups go with them
+	for _, species := range []string{"Homo erectus", "Homo sapiens", "Pan paniscus"} {
+		seq := sequence.NewSequence(species, []byte("ATG"))
+		seq.Species = species
+		seq.Gene = "12S"
+		tnt.AddSequence(seq)
+	}
+	if _, err := tnt.GenerateMetaData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	buf := bytes.Buffer{}
+	if err := tnt.WriteXGroups(&buf, tnt.CharacterGroups); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expectedGroups := `
+xgroup
+=0 (start) 3.5 14.16
+=1 (mito) 3.21
+;`
+	if got := buf.String(); got != expectedGroups {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expectedGroups, got)
+	}
+}
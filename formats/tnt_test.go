@@ -2,6 +2,7 @@ package formats_test
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/yarbelk/refasta/formats"
@@ -136,3 +137,129 @@ func TestBlankSequenceDoesNotChangeType(t *testing.T) {
 		t.Errorf("Expected the nstates to be '%s', got '%s'", expected, got)
 	}
 }
+
+func TestSetOutgroupUnknownSpeciesListsCloseMatches(t *testing.T) {
+	tnt := &formats.TNT{}
+	tnt.AddSequence(phylipTestSequences()...)
+
+	err := tnt.SetOutgroup("Homo sapien")
+	if err == nil {
+		t.Fatalf("Expected an error, got <nil>")
+	}
+	invalid, ok := err.(sequence.InvalidSequence)
+	if !ok || invalid.Errno != sequence.UNKNOWN_SPECIES {
+		t.Fatalf("Expected an InvalidSequence with Errno %d, got %v", sequence.UNKNOWN_SPECIES, err)
+	}
+	if !strings.Contains(invalid.Details, "did you mean Homo sapiens") {
+		t.Errorf("Expected the close match in the details, got '%s'", invalid.Details)
+	}
+	if len(tnt.Outgroups) != 0 {
+		t.Errorf("Expected no outgroup to be set, got %v", tnt.Outgroups)
+	}
+}
+
+func TestWriteSequencesWithOutgroups(t *testing.T) {
+	sequence1 := sequence.NewSequence("A a", []byte("ATAGCTACG"))
+	sequence1.Species = "A a"
+	sequence1.Gene = "ATP8"
+
+	sequence2 := sequence.NewSequence("B b", []byte("ATAGTCACG"))
+	sequence2.Species = "B b"
+	sequence2.Gene = "ATP8"
+
+	sequence3 := sequence.NewSequence("C c", []byte("ATAGCTACG"))
+	sequence3.Species = "C c"
+	sequence3.Gene = "ATP8"
+
+	tnt := &formats.TNT{Title: "Title Here"}
+	tnt.AddSequence(sequence1, sequence2, sequence3)
+	if err := tnt.SetOutgroup("C c", "B b"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := tnt.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := `nstates DNA;
+xread
+'Title Here'
+9 3
+C_c ATAGCTACG
+B_b ATAGTCACG
+A_a ATAGCTACG
+;
+agroup =0 (outgroup) 0 1;
+outgroup 0;
+xgroup
+=0 (ATP8) 0.8
+;`
+	got := buf.String()
+	if got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+
+	read := &formats.TNT{}
+	if err := read.Parse(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(read.Outgroups) != 2 || read.Outgroups[0] != "C c" || read.Outgroups[1] != "B b" {
+		t.Errorf("Expected the outgroups to be read back as [C c B b], got %v", read.Outgroups)
+	}
+}
+
+func TestWriteSequencesMixedTypesIsInterleaved(t *testing.T) {
+	sequence1 := sequence.NewSequence("A a", []byte("ATAGCTACG"))
+	sequence1.Species = "A a"
+	sequence1.Gene = "ATP8"
+
+	sequence2 := sequence.NewSequence("B b", []byte("ATAGTCACG"))
+	sequence2.Species = "B b"
+	sequence2.Gene = "ATP8"
+
+	sequence3 := sequence.NewSequence("A a", []byte("0120"))
+	sequence3.Species = "A a"
+	sequence3.Gene = "morphology"
+
+	sequence4 := sequence.NewSequence("B b", []byte("01[12]?"))
+	sequence4.Species = "B b"
+	sequence4.Gene = "morphology"
+
+	tnt := &formats.TNT{Title: "Title Here"}
+	tnt.AddSequence(sequence1, sequence2, sequence3, sequence4)
+
+	buf := bytes.Buffer{}
+	if err := tnt.WriteSequences(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := `nstates 32;
+xread
+'Title Here'
+13 2
+&[dna]
+A_a ATAGCTACG
+B_b ATAGTCACG
+&[num]
+A_a 0120
+B_b 01[12]?
+;
+blocks 0 9;
+cnames
+[1 ATP8;
+[2 morphology;
+;`
+	got := buf.String()
+	if got != expected {
+		t.Errorf("Expected:\n\n\"%s\"\n\nGot:\n\n\"%s\"", expected, got)
+	}
+
+	read := &formats.TNT{}
+	if err := read.Parse(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := string(read.Sequences["morphology"]["B b"].Seq); got != "01[12]?" {
+		t.Errorf("Expected the morphology to be read back as '01[12]?', got '%s'", got)
+	}
+}
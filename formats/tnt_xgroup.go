@@ -0,0 +1,228 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// CharacterGroup is a named set of characters, written out as a TNT xgroup
+// so that scripts can refer to them by name.  The characters are whole
+// genes, character positions in the concatenated matrix (zero indexed,
+// as TNT counts them), and positions within a gene (also zero indexed),
+// which stay with the gene wherever it ends up in the matrix.
+type CharacterGroup struct {
+	Name           string
+	Genes          []string
+	Characters     []int
+	GeneCharacters map[string][]int
+}
+
+// TNT_CODON_GROUPS are the names of the groups for each codon position
+var TNT_CODON_GROUPS = [3]string{"first", "second", "third"}
+
+/*
+ParseCharacterGroup reads a character group from the command line; a name,
+and a comma separated list of genes and character ranges.  The ranges are
+one indexed, like the partition files.
+
+	exons=ATP6,ATP8
+	mito=1-11,15
+*/
+func ParseCharacterGroup(spec string) (CharacterGroup, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return CharacterGroup{}, characterGroupError("expected NAME=GENE,START-END,... got '%s'", spec)
+	}
+	return parseCharacterList(strings.TrimSpace(parts[0]), parts[1])
+}
+
+func characterGroupError(details string, args ...interface{}) error {
+	return sequence.FormatError{
+		Message: "Badly formated character group",
+		Details: fmt.Sprintf(details, args...),
+		Errno:   sequence.BAD_FORMAT,
+	}
+}
+
+// parseCharacterList reads a comma separated list of genes and (one
+// indexed) character ranges into a group.  Like a NEXUS charset, a range
+// can take every Nth character; `3-18\3`
+func parseCharacterList(name, list string) (CharacterGroup, error) {
+	group := CharacterGroup{Name: name}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		step := 1
+		if i := strings.Index(item, "\\"); i != -1 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return CharacterGroup{}, characterGroupError("'%s' in group %s has a bad step", item, name)
+			}
+			item = item[:i]
+		}
+		bounds := strings.SplitN(item, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil && step == 1 {
+			// Not a number, so it's a gene name
+			group.Genes = append(group.Genes, item)
+			continue
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return CharacterGroup{}, characterGroupError("'%s' in group %s isn't a character range", item, name)
+			}
+		}
+		if start < 1 || end < start {
+			return CharacterGroup{}, characterGroupError("'%s' in group %s isn't a character range; they start at 1", item, name)
+		}
+		for i := start; i <= end; i += step {
+			group.Characters = append(group.Characters, i-1)
+		}
+	}
+	return group, nil
+}
+
+// AddCharacterGroup (or multiple) to be written out as xgroups
+func (t *TNT) AddCharacterGroup(groups ...CharacterGroup) {
+	t.CharacterGroups = append(t.CharacterGroups, groups...)
+}
+
+// characters returns the (sorted) positions of the characters in a group,
+// checking that they are all in the matrix
+func (t *TNT) characters(group CharacterGroup, ranges []geneRange) ([]int, error) {
+	characters := make([]int, 0, len(group.Characters))
+	for _, gene := range group.Genes {
+		r, err := groupGeneRange(group, gene, ranges)
+		if err != nil {
+			return nil, err
+		}
+		for i := r.Start; i < r.End; i++ {
+			characters = append(characters, i)
+		}
+	}
+	for gene, positions := range group.GeneCharacters {
+		r, err := groupGeneRange(group, gene, ranges)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range positions {
+			if c >= r.End-r.Start {
+				return nil, sequence.InvalidSequence{
+					Message: "Character group is outside of the gene",
+					Details: fmt.Sprintf("group %s has character %d of %s, but it only has %d characters", group.Name, c+1, gene, r.End-r.Start),
+					Errno:   sequence.UNKNOWN,
+				}
+			}
+			characters = append(characters, r.Start+c)
+		}
+	}
+	length := t.getTotalLength()
+	for _, c := range group.Characters {
+		if c >= length {
+			return nil, sequence.InvalidSequence{
+				Message: "Character group is outside of the matrix",
+				Details: fmt.Sprintf("group %s has character %d, but there are only %d characters", group.Name, c+1, length),
+				Errno:   sequence.UNKNOWN,
+			}
+		}
+		characters = append(characters, c)
+	}
+	sort.Ints(characters)
+	unique := characters[:0]
+	for _, c := range characters {
+		if len(unique) == 0 || c != unique[len(unique)-1] {
+			unique = append(unique, c)
+		}
+	}
+	return unique, nil
+}
+
+// groupGeneRange returns where a gene in a character group is in the matrix
+func groupGeneRange(group CharacterGroup, gene string, ranges []geneRange) (geneRange, error) {
+	genes := make([]string, len(ranges))
+	for i, r := range ranges {
+		if r.Gene == gene {
+			return r, nil
+		}
+		genes[i] = r.Gene
+	}
+	return geneRange{}, sequence.InvalidSequence{
+		Message: "Character group refers to an unknown gene",
+		Details: fmt.Sprintf("group %s has gene %s; close genes are: %s", group.Name, gene, strings.Join(sequence.CloseMatches(gene, genes), ", ")),
+		Errno:   sequence.UNKNOWN,
+	}
+}
+
+// codonGroups splits the DNA genes up by codon position; each gene starts
+// at the first position
+func (t *TNT) codonGroups(ranges []geneRange) []CharacterGroup {
+	groups := make([]CharacterGroup, len(TNT_CODON_GROUPS))
+	for i, name := range TNT_CODON_GROUPS {
+		groups[i].Name = name
+	}
+	for _, r := range ranges {
+		if t.geneType(r.Gene) != sequence.DNA_TYPE {
+			continue
+		}
+		for i := r.Start; i < r.End; i++ {
+			position := (i - r.Start) % 3
+			groups[position].Characters = append(groups[position].Characters, i)
+		}
+	}
+	return groups
+}
+
+// characterList formats the characters the way TNT lists them, with runs
+// of characters as a range; `0.10 12 15.18`
+func characterList(characters []int) string {
+	list := make([]string, 0, len(characters))
+	for i := 0; i < len(characters); i++ {
+		start := characters[i]
+		for i+1 < len(characters) && characters[i+1] == characters[i]+1 {
+			i++
+		}
+		if characters[i] == start {
+			list = append(list, strconv.Itoa(start))
+		} else {
+			list = append(list, fmt.Sprintf("%d.%d", start, characters[i]))
+		}
+	}
+	return strings.Join(list, " ")
+}
+
+/*
+WriteXGroups writes out the character groups, numbered in the order they
+are given.  Groups without any characters are left out.
+
+	xgroup
+	=0 (ATP8) 0.18
+	=1 (first) 0 3 6 9 12 15 18
+	;
+*/
+func (t *TNT) WriteXGroups(writer io.Writer, groups []CharacterGroup) error {
+	ranges := t.geneRanges()
+	lines := make([]string, 0, len(groups))
+	for _, group := range groups {
+		characters, err := t.characters(group, ranges)
+		if err != nil {
+			return err
+		}
+		if len(characters) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("=%d (%s) %s", len(lines), sequence.Safe(group.Name), characterList(characters)))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(writer, "\nxgroup\n%s\n;", strings.Join(lines, "\n"))
+	return err
+}
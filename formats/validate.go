@@ -0,0 +1,211 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// The kinds of problem found by Validate
+const (
+	PROBLEM_LENGTH    = "length"
+	PROBLEM_CHARACTER = "character"
+	PROBLEM_EMPTY     = "empty"
+	PROBLEM_MISSING   = "missing"
+	PROBLEM_TYPE      = "type"
+	PROBLEM_DUPLICATE = "duplicate"
+	// PROBLEM_PARSE is a file that couldn't be read; it is added by the
+	// command, as the file never makes it into the matrix
+	PROBLEM_PARSE = "parse"
+)
+
+// warnings are the kinds of problem which are normal in a real data set,
+// and so don't make the data invalid
+var warnings = map[string]bool{
+	PROBLEM_MISSING: true,
+}
+
+// validCharacters are the characters allowed in any of the sequence types
+var validCharacters = func() map[byte]bool {
+	valid := make(map[byte]bool)
+	for _, c := range []byte(sequence.DNA_ALPHABET + sequence.DNA_AMBIGUITY + sequence.PROTEIN_ALPHABET + "X0123456789-?[]") {
+		valid[c] = true
+		if c >= 'A' && c <= 'Z' {
+			valid[c-'A'+'a'] = true
+		}
+	}
+	return valid
+}()
+
+// Problem is one thing wrong with the data
+type Problem struct {
+	Kind    string `json:"kind"`
+	Gene    string `json:"gene,omitempty"`
+	Species string `json:"species,omitempty"`
+	Message string `json:"message"`
+	Warning bool   `json:"warning,omitempty"`
+}
+
+func (p Problem) String() string {
+	where := []string{}
+	for _, s := range []string{p.Gene, p.Species} {
+		if s != "" {
+			where = append(where, s)
+		}
+	}
+	kind := p.Kind
+	if p.Warning {
+		kind = "warning: " + kind
+	}
+	if len(where) == 0 {
+		return fmt.Sprintf("%s: %s", kind, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", kind, strings.Join(where, ", "), p.Message)
+}
+
+// Report is everything found wrong with a matrix by Validate
+type Report struct {
+	Genes    int       `json:"genes"`
+	Species  int       `json:"species"`
+	Problems []Problem `json:"problems"`
+}
+
+// Errors is the number of problems that aren't warnings; or all of them
+// if strict
+func (r Report) Errors(strict bool) (errors int) {
+	for _, problem := range r.Problems {
+		if strict || !problem.Warning {
+			errors++
+		}
+	}
+	return
+}
+
+// WriteText writes the report as one line per problem, and a summary
+func (r Report) WriteText(w io.Writer) error {
+	for _, problem := range r.Problems {
+		if _, err := fmt.Fprintln(w, problem.String()); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d genes, %d species, %d problems (%d warnings)\n",
+		r.Genes, r.Species, len(r.Problems), len(r.Problems)-r.Errors(false))
+	return err
+}
+
+// WriteJSON writes the report as JSON
+func (r Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// unknownCharacters returns the characters in the data which aren't in any
+// of the alphabets, and the (1 based) position of the first one
+func unknownCharacters(data sequence.SequenceData) (string, int) {
+	unknown := []string{}
+	seen := make(map[byte]bool)
+	first := 0
+	for i, char := range data.Characters() {
+		for _, c := range char {
+			if validCharacters[c] {
+				continue
+			}
+			if first == 0 {
+				first = i + 1
+			}
+			if !seen[c] {
+				seen[c] = true
+				unknown = append(unknown, fmt.Sprintf("'%c'", c))
+			}
+		}
+	}
+	return strings.Join(unknown, ", "), first
+}
+
+/*
+Validate checks all the sequences in the matrix, and reports every problem
+found instead of stopping at the first one: genes with sequences of
+different lengths, unknown characters, empty genes, species missing from a
+gene, genes with more than one type of sequence, and more than one
+sequence for the same species and gene.  Missing species are only a
+warning, as they are filled in with gaps.
+*/
+func (t *Matrix) Validate() Report {
+	genes := make([]string, 0, len(t.Sequences))
+	for gene, _ := range t.Sequences {
+		genes = append(genes, gene)
+	}
+	sort.Strings(genes)
+
+	report := Report{Genes: len(genes), Species: len(t.speciesNames), Problems: []Problem{}}
+	add := func(kind, gene, species, message string, args ...interface{}) {
+		report.Problems = append(report.Problems, Problem{
+			Kind:    kind,
+			Gene:    gene,
+			Species: species,
+			Message: fmt.Sprintf(message, args...),
+			Warning: warnings[kind],
+		})
+	}
+
+	for _, gene := range genes {
+		lengths := make(map[int][]string)
+		types := make(map[sequence.SequenceType][]string)
+		missing := []string{}
+		hasData := false
+		for _, name := range t.speciesNames {
+			seq, ok := t.Sequences[gene][name]
+			if !ok || len(seq.Seq) == 0 {
+				missing = append(missing, name)
+				continue
+			}
+			// Counted from the data, as the length from the scanner stops
+			// at an unknown character
+			length := len(seq.Seq.Characters())
+			lengths[length] = append(lengths[length], seq.Name)
+			if completeness(seq.Seq) == 0 {
+				missing = append(missing, name)
+				continue
+			}
+			hasData = true
+			if unknown, position := unknownCharacters(seq.Seq); unknown != "" {
+				add(PROBLEM_CHARACTER, gene, name, "unknown characters %s, first at character %d", unknown, position)
+			} else if seqType := seq.Type(); seqType != sequence.BLANK_TYPE {
+				types[seqType] = append(types[seqType], name)
+			}
+		}
+
+		if !hasData {
+			add(PROBLEM_EMPTY, gene, "", "no species have any data")
+			continue
+		}
+		if len(lengths) > 1 {
+			err := fmtInvalidSequenceErr(gene, lengths).(sequence.InvalidSequence)
+			add(PROBLEM_LENGTH, gene, "", "%s", err.Details)
+		}
+		if len(missing) > 0 {
+			add(PROBLEM_MISSING, gene, "", "%d species have no data: %s", len(missing), strings.Join(missing, ", "))
+		}
+		if len(types) > 1 {
+			mixed := []string{}
+			for seqType, names := range types {
+				mixed = append(mixed, fmt.Sprintf("%s (%s)", seqType, strings.Join(names, ", ")))
+			}
+			sort.Strings(mixed)
+			add(PROBLEM_TYPE, gene, "", "more than one type of sequence: %s", strings.Join(mixed, "; "))
+		}
+	}
+
+	for _, collision := range t.Collisions {
+		add(PROBLEM_DUPLICATE, collision.Gene, collision.Species, "%d sequences: %s", len(collision.Names), strings.Join(collision.Names, ", "))
+	}
+	return report
+}
@@ -0,0 +1,72 @@
+package formats_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func validateSequence(species, gene, data string) sequence.Sequence {
+	seq := sequence.NewSequence(species, []byte(data))
+	seq.Species = species
+	seq.Gene = gene
+	return seq
+}
+
+func TestValidateReportsEverything(t *testing.T) {
+	matrix := formats.Matrix{Duplicates: formats.DUPLICATE_FIRST}
+	matrix.AddSequence(
+		validateSequence("Homo sapiens", "ATP8", "ATAGCTAG"),
+		validateSequence("Homo sapiens", "ATP8", "ATAGCTAA"),
+		validateSequence("Pan paniscus", "ATP8", "ATAGCTAGC"),
+		validateSequence("Homo sapiens", "COX1", "MKLVWQ"),
+		validateSequence("Gorilla gorilla", "COX1", "ATAGCT"),
+		validateSequence("Pan paniscus", "COX1", "MK*VWQ"),
+		validateSequence("Homo sapiens", "ND1", "----"),
+	)
+	report := matrix.Validate()
+
+	kinds := make(map[string]int)
+	for _, problem := range report.Problems {
+		kinds[problem.Kind]++
+	}
+	expected := map[string]int{
+		formats.PROBLEM_LENGTH:    1,
+		formats.PROBLEM_CHARACTER: 1,
+		formats.PROBLEM_EMPTY:     1,
+		formats.PROBLEM_MISSING:   1,
+		formats.PROBLEM_TYPE:      1,
+		formats.PROBLEM_DUPLICATE: 1,
+	}
+	for kind, count := range expected {
+		if kinds[kind] != count {
+			t.Errorf("Expected %d %s problems, got %d: %v", count, kind, kinds[kind], report.Problems)
+		}
+	}
+	if errors := report.Errors(false); errors != 5 {
+		t.Errorf("Expected 5 errors, not counting the warnings, got %d", errors)
+	}
+	if errors := report.Errors(true); errors != 6 {
+		t.Errorf("Expected 6 errors when strict, got %d", errors)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	matrix := formats.Matrix{}
+	matrix.AddSequence(phylipTestSequences()...)
+
+	buf := bytes.Buffer{}
+	if err := matrix.Validate().WriteJSON(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	report := formats.Report{}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("Expected valid JSON, got %v:\n%s", err, buf.String())
+	}
+	if report.Genes != 2 || report.Species != 2 || len(report.Problems) != 0 {
+		t.Errorf("Expected 2 genes, 2 species and no problems, got %+v", report)
+	}
+}
@@ -0,0 +1,188 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yarbelk/refasta/scanner"
+)
+
+// wordScanner splits up the command based formats (NEXUS, TNT) into
+// words.  The scanner.Reader keeps track of the line and column it is at,
+// so the parsers can say where the bad data is.
+type wordScanner struct {
+	reader *scanner.Reader
+	// punctuation are the characters which are always a word on their own
+	punctuation string
+	// comments is true if `[...]` should be skipped as a comment
+	comments bool
+}
+
+func newWordScanner(reader io.Reader, punctuation string, comments bool) *wordScanner {
+	return &wordScanner{
+		reader:      scanner.NewReader(reader),
+		punctuation: punctuation,
+		comments:    comments,
+	}
+}
+
+// Position is the line and column of the last rune read
+func (w *wordScanner) Position() (line, column int) {
+	return w.reader.Position()
+}
+
+// position is a human readable position in the file; used for errors
+func (w *wordScanner) position() string {
+	line, column := w.reader.Position()
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
+// read the next rune
+func (w *wordScanner) read() (rune, error) {
+	ch, _, err := w.reader.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	return ch, nil
+}
+
+// unread the last rune; you can only do this once between reads
+func (w *wordScanner) unread() {
+	w.reader.UnreadRune()
+}
+
+// skipComment skips a (possibly nested) `[...]` comment; the opening '['
+// has already been read
+func (w *wordScanner) skipComment() error {
+	depth := 1
+	start := w.position()
+	for depth > 0 {
+		ch, err := w.read()
+		if err != nil {
+			return fmt.Errorf("unterminated comment starting at %s", start)
+		}
+		switch ch {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// skipSpace skips whitespace and comments.  If stopAtNewline is true it will
+// stop before a new line, and return true
+func (w *wordScanner) skipSpace(stopAtNewline bool) (bool, error) {
+	for {
+		ch, err := w.read()
+		switch {
+		case err != nil:
+			return false, err
+		case ch == '\n' && stopAtNewline:
+			w.unread()
+			return true, nil
+		case scanner.IsWhitespace(ch):
+			continue
+		case ch == '[' && w.comments:
+			if err := w.skipComment(); err != nil {
+				return false, err
+			}
+		default:
+			w.unread()
+			return false, nil
+		}
+	}
+}
+
+// peek returns the next non whitespace rune, without consuming it
+func (w *wordScanner) peek() (rune, error) {
+	if _, err := w.skipSpace(false); err != nil {
+		return 0, err
+	}
+	ch, err := w.read()
+	if err != nil {
+		return 0, err
+	}
+	w.unread()
+	return ch, nil
+}
+
+// quoted reads a single quoted word; the opening quote has already been
+// read.  Two single quotes in a row are a literal quote.
+func (w *wordScanner) quoted() (string, error) {
+	buf := bytes.Buffer{}
+	start := w.position()
+	for {
+		ch, err := w.read()
+		if err != nil {
+			return "", fmt.Errorf("unterminated quote starting at %s", start)
+		}
+		if ch != '\'' {
+			buf.WriteRune(ch)
+			continue
+		}
+		next, err := w.read()
+		if err == nil && next == '\'' {
+			buf.WriteRune('\'')
+			continue
+		}
+		if err == nil {
+			w.unread()
+		}
+		return buf.String(), nil
+	}
+}
+
+// Word returns the next word, and if it was quoted.  A word is either a
+// single punctuation character, a quoted string, or a run of anything else
+// that isn't whitespace.  io.EOF is returned at the end of the input.
+func (w *wordScanner) Word() (string, bool, error) {
+	if _, err := w.skipSpace(false); err != nil {
+		return "", false, err
+	}
+	ch, err := w.read()
+	if err != nil {
+		return "", false, err
+	}
+	if ch == '\'' {
+		word, err := w.quoted()
+		return word, true, err
+	}
+	if strings.ContainsRune(w.punctuation, ch) {
+		return string(ch), false, nil
+	}
+	buf := bytes.Buffer{}
+	buf.WriteRune(ch)
+	for {
+		ch, err := w.read()
+		if err != nil {
+			break
+		}
+		if scanner.IsWhitespace(ch) || ch == '\'' ||
+			strings.ContainsRune(w.punctuation, ch) ||
+			(ch == '[' && w.comments) {
+			w.unread()
+			break
+		}
+		buf.WriteRune(ch)
+	}
+	return buf.String(), false, nil
+}
+
+// Command returns all the words up to the next ';', which is consumed.
+func (w *wordScanner) Command() ([]string, error) {
+	words := []string{}
+	for {
+		word, quoted, err := w.Word()
+		if err != nil {
+			return words, err
+		}
+		if word == ";" && !quoted {
+			return words, nil
+		}
+		words = append(words, word)
+	}
+}
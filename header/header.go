@@ -0,0 +1,152 @@
+/*
+Package header reads the species, gene and other data out of a sequence's
+header (the FASTA ID), using a schema.
+
+A schema is a regular expression with named captures.  The captures
+`species` and `gene` set the Species and Gene of the sequence; anything
+else is put in its Fields.  Underscores in the species are read as spaces.
+
+	(?P<species>[^|]+)\|(?P<voucher>[^|]*)\|(?P<gene>[^|]+)
+
+There are presets for the common headers; see Presets.
+*/
+package header
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+const (
+	SPECIES = "species"
+	GENE    = "gene"
+)
+
+// Schema for reading the data out of a header
+type Schema struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Modifiers reads `[key=value]` pairs, as used by NCBI, into the
+	// fields; with organism as the species, and gene as the gene
+	Modifiers bool
+}
+
+// Presets are the schemas for the common header formats
+var Presets = map[string]*Schema{
+	// Homo_sapiens|USNM123|COI
+	"genus_species|voucher|gene": &Schema{
+		Name:    "genus_species|voucher|gene",
+		Pattern: regexp.MustCompile(`^(?P<species>[^|]+)\|(?P<voucher>[^|]*)\|(?P<gene>[^|]+)$`),
+	},
+	// MN123456.1 Homo sapiens voucher USNM123 cytochrome oxidase subunit I [organism=Homo sapiens] [gene=COI]
+	"ncbi": &Schema{
+		Name:      "ncbi",
+		Pattern:   regexp.MustCompile(`^(?P<accession>\S+)\s*(?P<description>[^\[]*)`),
+		Modifiers: true,
+	},
+}
+
+// modifierRegex matches NCBI's `[key=value]` source modifiers
+var modifierRegex = regexp.MustCompile(`\[\s*([^=\]]+?)\s*=\s*([^\]]*?)\s*\]`)
+
+// ncbiModifiers maps the NCBI modifiers onto the sequence fields
+var ncbiModifiers = map[string]string{
+	"organism": SPECIES,
+	"gene":     GENE,
+}
+
+// PresetNames returns the names of the presets, sorted
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name, _ := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func schemaError(details string, args ...interface{}) error {
+	return sequence.FormatError{
+		Message: "Bad header schema",
+		Details: fmt.Sprintf(details, args...),
+		Errno:   sequence.BAD_FORMAT,
+	}
+}
+
+/*
+New returns the preset schema with that name, or if there isn't one,
+compiles it as a regular expression.  The regular expression must have at
+least one named capture.
+*/
+func New(schema string) (*Schema, error) {
+	if preset, ok := Presets[schema]; ok {
+		return preset, nil
+	}
+	pattern, err := regexp.Compile(schema)
+	if err != nil {
+		return nil, schemaError("'%s' isn't one of the presets (%s), or a valid regular expression: %s",
+			schema, strings.Join(PresetNames(), ", "), err.Error())
+	}
+	named := false
+	for _, name := range pattern.SubexpNames() {
+		named = named || name != ""
+	}
+	if !named {
+		return nil, schemaError("'%s' doesn't have any named captures, eg (?P<species>...)", schema)
+	}
+	return &Schema{Name: schema, Pattern: pattern}, nil
+}
+
+// Fields returns the fields read from the header; or an error if it
+// doesn't match the schema
+func (s *Schema) Fields(id string) (map[string]string, error) {
+	match := s.Pattern.FindStringSubmatch(id)
+	if match == nil {
+		return nil, sequence.FormatError{
+			Message: "Header doesn't match the schema",
+			Details: fmt.Sprintf("'%s' doesn't match %s", id, s.Name),
+			Errno:   sequence.BAD_FORMAT,
+		}
+	}
+	fields := make(map[string]string)
+	for i, name := range s.Pattern.SubexpNames() {
+		if name != "" && match[i] != "" {
+			fields[name] = strings.TrimSpace(match[i])
+		}
+	}
+	if s.Modifiers {
+		for _, modifier := range modifierRegex.FindAllStringSubmatch(id, -1) {
+			key := strings.ToLower(modifier[1])
+			if field, ok := ncbiModifiers[key]; ok {
+				key = field
+			}
+			fields[key] = modifier[2]
+		}
+	}
+	return fields, nil
+}
+
+// Apply the schema to a sequence, setting its Species, Gene and Fields.
+// The gene is only changed if the header has one.
+func (s *Schema) Apply(seq *sequence.Sequence) error {
+	fields, err := s.Fields(seq.Name)
+	if err != nil {
+		return err
+	}
+	if species, ok := fields[SPECIES]; ok {
+		seq.Species = strings.Replace(species, "_", " ", -1)
+		delete(fields, SPECIES)
+	}
+	if gene, ok := fields[GENE]; ok {
+		seq.Gene = gene
+		delete(fields, GENE)
+	}
+	if len(fields) > 0 {
+		seq.Fields = fields
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package header_test
+
+import (
+	"testing"
+
+	"github.com/yarbelk/refasta/header"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func TestVoucherPreset(t *testing.T) {
+	schema, err := header.New("genus_species|voucher|gene")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	seq := sequence.NewSequence("Homo_sapiens|USNM123|COI", []byte("ATAG"))
+	seq.Gene = "from_file"
+	if err := schema.Apply(&seq); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if seq.Species != "Homo sapiens" {
+		t.Errorf("Expected the species to be 'Homo sapiens', got '%s'", seq.Species)
+	}
+	if seq.Gene != "COI" {
+		t.Errorf("Expected the gene to be 'COI', got '%s'", seq.Gene)
+	}
+	if seq.Fields["voucher"] != "USNM123" {
+		t.Errorf("Expected the voucher to be 'USNM123', got %v", seq.Fields)
+	}
+}
+
+func TestNCBIPreset(t *testing.T) {
+	schema, err := header.New("ncbi")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	seq := sequence.NewSequence("MN123456.1 cytochrome oxidase subunit I [organism=Homo sapiens] [gene=COX1] [specimen-voucher=USNM 123]", []byte("ATAG"))
+	seq.Gene = "from_file"
+	if err := schema.Apply(&seq); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if seq.Species != "Homo sapiens" {
+		t.Errorf("Expected the species to be 'Homo sapiens', got '%s'", seq.Species)
+	}
+	if seq.Gene != "COX1" {
+		t.Errorf("Expected the gene to be 'COX1', got '%s'", seq.Gene)
+	}
+	expected := map[string]string{
+		"accession":        "MN123456.1",
+		"description":      "cytochrome oxidase subunit I",
+		"specimen-voucher": "USNM 123",
+	}
+	for key, value := range expected {
+		if seq.Fields[key] != value {
+			t.Errorf("Expected %s to be '%s', got '%s'", key, value, seq.Fields[key])
+		}
+	}
+}
+
+func TestRegexSchemaKeepsGeneWhenNotCaptured(t *testing.T) {
+	schema, err := header.New(`^(?P<species>\w+ \w+) (?P<locality>.+)$`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	seq := sequence.NewSequence("Homo sapiens Kenya", []byte("ATAG"))
+	seq.Gene = "ATP8"
+	if err := schema.Apply(&seq); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if seq.Species != "Homo sapiens" || seq.Gene != "ATP8" || seq.Fields["locality"] != "Kenya" {
+		t.Errorf("Expected 'Homo sapiens', 'ATP8' and locality 'Kenya', got '%s', '%s' and %v", seq.Species, seq.Gene, seq.Fields)
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	for _, schema := range []string{`(unbalanced`, `\w+ \w+`} {
+		if _, err := header.New(schema); err == nil {
+			t.Errorf("Expected an error for '%s', got <nil>", schema)
+		}
+	}
+
+	schema, _ := header.New("genus_species|voucher|gene")
+	seq := sequence.NewSequence("Homo sapiens", []byte("ATAG"))
+	err := schema.Apply(&seq)
+	if err == nil {
+		t.Fatalf("Expected an error for a header that doesn't match, got <nil>")
+	}
+	if err.(sequence.FormatError).Errno != sequence.BAD_FORMAT {
+		t.Errorf("Expected a BAD_FORMAT error, got %v", err)
+	}
+}
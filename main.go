@@ -1,19 +1,40 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/header"
+	"github.com/yarbelk/refasta/pipeline"
 	"github.com/yarbelk/refasta/sequence"
+	"github.com/yarbelk/refasta/taxa"
 	"gopkg.in/urfave/cli.v1"
 )
 
-var sequences []sequence.Sequence
+// duplicatePolicy is what the concatenated formats do with more than one
+// sequence for the same species and gene
+var duplicatePolicy formats.DuplicatePolicy
+
+// completenessFilter removes the taxa and genes with too little data from
+// the concatenated formats
+var completenessFilter formats.Filter
+
+// keepParsing carries on with the other files when one can't be parsed,
+// and collects the errors in parseErrors; so validate can report them all
+var keepParsing bool
+var parseErrors []error
 
 var version string
 
@@ -39,31 +60,286 @@ func (f FakeReadCloser) Close() error {
 	return nil
 }
 
+// FakeWriteCloser buffers the writes to stdout; closing it flushes them,
+// but leaves stdout open
 type FakeWriteCloser struct {
-	io.Writer
+	*bufio.Writer
 }
 
+// TNTContext is the options of the TNT output.  The InputOutgroups and
+// InputCharacterGroups are the ones read from TNT input; they are written
+// out again, unless the output is given its own outgroups
 type TNTContext struct {
-	Title    string
-	Outgroup string
+	Title                string
+	Outgroups            []string
+	CharacterGroups      []string
+	CodonGroups          bool
+	CharacterCodes       []string
+	CCodeFile            string
+	Partitions           PartitionContext
+	InputOutgroups       []string
+	InputCharacterGroups []formats.CharacterGroup
+}
+
+type PhylipContext struct {
+	Strict         bool
+	Interleaved    bool
+	LineWidth      int
+	NameMap        string
+	Outgroups      []string
+	Partitions     PartitionContext
+	InputOutgroups []string
+}
+
+type NexusContext struct {
+	Outgroups      []string
+	Partitions     PartitionContext
+	InputOutgroups []string
+}
+
+// ConvertContext is the options of all the formats convert can write out;
+// the TNT outgroups are used to order the taxa of every one of them
+type ConvertContext struct {
+	LineWidth  int
+	TNT        TNTContext
+	Phylip     PhylipContext
+	Partitions PartitionContext
+}
+
+// PartitionContext is the partition files to write out along side one of
+// the concatenated formats
+type PartitionContext struct {
+	RAxML          string
+	IQTree         string
+	CodonPositions bool
+}
+
+// partitionFlags are shared by all of the concatenated output formats
+var partitionFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "raxml-partitions",
+		Value: "",
+		Usage: "Optional `FILE` to write a RAxML-NG partition file to, with a partition per gene",
+	},
+	cli.StringFlag{
+		Name:  "iqtree-partitions",
+		Value: "",
+		Usage: "Optional `FILE` to write an IQ-TREE (NEXUS sets) partition file to, with a charset per gene",
+	},
+	cli.BoolFlag{
+		Name:  "codon-positions",
+		Usage: "Split the DNA genes in the partition files up by codon position",
+	},
+}
+
+func newPartitionContext(c *cli.Context) PartitionContext {
+	return PartitionContext{
+		RAxML:          c.String("raxml-partitions"),
+		IQTree:         c.String("iqtree-partitions"),
+		CodonPositions: c.Bool("codon-positions"),
+	}
+}
+
+// tntFlags are the options of the TNT output
+var tntFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name: "outgroup",
+		Usage: "Optional `OUTGROUP` for TNT output.  If specified, this species will be used as the outgroup for TNT. " +
+			"Otherwise the outgroups of TNT input are kept, or the first (alphabetically) will be used.  This must be left blank, or be a valid species name " +
+			"from the input.  Give it more than once for multiple outgroups; the first is used to root the tree",
+	},
+	cli.StringFlag{
+		Name:  "tnt-title, t",
+		Value: "",
+		Usage: "`TITLE` for TNT output",
+	},
+	cli.StringSliceFlag{
+		Name: "xgroup",
+		Usage: "Named character `GROUP` to write out as an xgroup, as NAME=GENE,START-END,...  The genes are included whole, " +
+			"and the character ranges count from 1, the same as the partition files.  Give it more than once for more groups; " +
+			"they are added to the xgroups of TNT input",
+	},
+	cli.BoolFlag{
+		Name:  "codon-groups",
+		Usage: "Add xgroups named first, second and third for the codon positions of the DNA genes",
+	},
+	cli.StringSliceFlag{
+		Name: "ccode",
+		Usage: "Character `CODE` to write out as a ccode, as 'SETTINGS GENE,START-END,...'; eg 'inactive weight=2 ATP8,1-20'.  " +
+			"The settings are active, inactive, additive, nonadditive and weight=N.  Give it more than once for more codes; " +
+			"these are written after the ones from --ccode-file, and later codes override earlier ones",
+	},
+	cli.StringFlag{
+		Name:  "ccode-file",
+		Value: "",
+		Usage: "`FILE` of character codes, one per line in the same form as --ccode.  Anything after a # is ignored",
+	},
+}
+
+func newTNTContext(c *cli.Context, input inputData) TNTContext {
+	return TNTContext{
+		Title:                c.String("tnt-title"),
+		Outgroups:            c.StringSlice("outgroup"),
+		CharacterGroups:      c.StringSlice("xgroup"),
+		CodonGroups:          c.Bool("codon-groups"),
+		CharacterCodes:       c.StringSlice("ccode"),
+		CCodeFile:            c.String("ccode-file"),
+		Partitions:           newPartitionContext(c),
+		InputOutgroups:       input.Outgroups,
+		InputCharacterGroups: input.CharacterGroups,
+	}
+}
+
+// phylipFlags are the options of the PHYLIP output, except for the line
+// width; its default is different in the phylip and convert commands
+var phylipFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "strict",
+		Usage: "Use strict PHYLIP names; cut down to 10 characters, with a number added if that makes two the same",
+	},
+	cli.BoolFlag{
+		Name:  "interleaved",
+		Usage: "Write the data interleaved, instead of one line per taxon",
+	},
+	cli.StringFlag{
+		Name:  "name-map",
+		Value: "",
+		Usage: "Optional `FILE` to write a tab separated table of the PHYLIP names and the species names they came from",
+	},
+}
+
+func newPhylipContext(c *cli.Context, input inputData) PhylipContext {
+	return PhylipContext{
+		Strict:         c.Bool("strict"),
+		Interleaved:    c.Bool("interleaved"),
+		LineWidth:      c.Int("line-width"),
+		NameMap:        c.String("name-map"),
+		Partitions:     newPartitionContext(c),
+		InputOutgroups: input.Outgroups,
+	}
 }
 
 func (f FakeWriteCloser) Close() error {
-	return nil
+	return f.Flush()
+}
+
+// gzipWriteCloser compresses everything written to the file; closing it
+// finishes the gzip stream, and then closes the file
+type gzipWriteCloser struct {
+	*gzip.Writer
+	file io.Closer
 }
 
+func (g gzipWriteCloser) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.file.Close()
+		return err
+	}
+	return g.file.Close()
+}
+
+// decompressedReadCloser reads the decompressed data, and closes the file
+type decompressedReadCloser struct {
+	io.Reader
+	file io.Closer
+}
+
+func (d decompressedReadCloser) Close() error {
+	return d.file.Close()
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+var bzip2Magic = []byte("BZh")
+
+// compressionExts are the extensions of compressed files; they are taken
+// off before the format's extension and the gene name
+var compressionExts = []string{".gz", ".bz2"}
+
+// trimCompressionExt takes the compression extension (if there is one) off
+// of the file name
+func trimCompressionExt(file string) string {
+	for _, ext := range compressionExts {
+		if strings.HasSuffix(strings.ToLower(file), ext) {
+			return file[:len(file)-len(ext)]
+		}
+	}
+	return file
+}
+
+// decompress reads gzip and bzip2 data decompressed, and anything else as
+// it is; the compression is worked out from the magic bytes at the start,
+// not the file name
+func decompress(input io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	magic, err := buffered.Peek(len(bzip2Magic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(buffered), nil
+	}
+	return buffered, nil
+}
+
+// isStdio is true if the file name is "-" or "--", which are stdin for the
+// input and stdout for the output
+func isStdio(filename string) bool {
+	return filename == "-" || filename == "--"
+}
+
+// getOutputFilePointer opens the file to write to; "-" or "--" is stdout,
+// and a file name ending in .gz is written gzip compressed
 func getOutputFilePointer(filename string) (io.WriteCloser, error) {
-	if filename == "--" {
-		return FakeWriteCloser{os.Stdout}, nil
+	if isStdio(filename) {
+		return FakeWriteCloser{bufio.NewWriter(os.Stdout)}, nil
 	}
-	return os.Create(filename)
+	fd, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(strings.ToLower(filename), ".gz") {
+		return gzipWriteCloser{gzip.NewWriter(fd), fd}, nil
+	}
+	return fd, nil
 }
 
+// writeOutput opens the output (stdout if it is blank), writes to it, and
+// closes it again; a failure to close it is an error too, as that is when
+// compressed output is finished off, and stdout is flushed
+func writeOutput(output string, write func(io.Writer) error) error {
+	if output == "" {
+		output = "--"
+	}
+	fd, err := getOutputFilePointer(output)
+	if err != nil {
+		return err
+	}
+	if err := write(fd); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// getInputFilePointer opens the file to read from; "-" or "--" is stdin.
+// gzip and bzip2 compressed input is decompressed.
 func getInputFilePointer(filename string) (io.ReadCloser, error) {
-	if filename == "--" {
-		return FakeReadCloser{os.Stdin}, nil
+	var fd io.ReadCloser = FakeReadCloser{os.Stdin}
+	if !isStdio(filename) {
+		var err error
+		if fd, err = os.Open(filename); err != nil {
+			return nil, err
+		}
 	}
-	return os.Open(filename)
+	input, err := decompress(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return decompressedReadCloser{input, fd}, nil
 }
 
 func IsDirectory(path string) (bool, error) {
@@ -71,37 +347,71 @@ func IsDirectory(path string) (bool, error) {
 	return fileInfo.IsDir(), err
 }
 
+// isFormat is true if the file name has one of the extensions of the
+// format; with auto, every file except the hidden ones is read, and the
+// format is worked out from its contents
 func isFormat(file, format string) bool {
-	switch path.Ext(file) {
-	case ".fas", ".fasta":
+	if format == formats.AUTO_FORMAT {
+		return !strings.HasPrefix(filepath.Base(file), ".")
+	}
+	switch path.Ext(trimCompressionExt(file)) {
+	case ".fas", ".fasta", ".fa", ".fna", ".faa":
 		return format == formats.FASTA_FORMAT
+	case ".nex", ".nexus", ".nxs":
+		return format == formats.NEXUS_FORMAT
+	case ".tnt":
+		return format == formats.TNT_FORMAT
+	case ".phy", ".phylip":
+		return format == formats.PHYLIP_FORMAT
 	default:
 		return false
 	}
 }
 
-func dirInput(dir, format string, recurse bool) ([]string, error) {
+// dirInput returns the files of the format in the directory, and the ones
+// that were skipped because they aren't
+func dirInput(dir, format string, recurse bool) (filteredFiles, skipped []string, err error) {
 	absDir, err := filepath.Abs(dir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	files, err := ioutil.ReadDir(absDir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	filteredFiles := make([]string, 0, 10)
+	filteredFiles = make([]string, 0, 10)
 	for _, file := range files {
-		if file.IsDir() && recurse {
-			f, err := dirInput(filepath.Join(absDir, file.Name()), format, true)
+		if file.IsDir() {
+			if !recurse {
+				continue
+			}
+			f, s, err := dirInput(filepath.Join(absDir, file.Name()), format, true)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			filteredFiles = append(filteredFiles, f...)
+			skipped = append(skipped, s...)
 		} else if isFormat(file.Name(), format) {
 			filteredFiles = append(filteredFiles, filepath.Join(absDir, file.Name()))
+		} else {
+			skipped = append(skipped, filepath.Join(absDir, file.Name()))
 		}
 	}
-	return filteredFiles, nil
+	return filteredFiles, skipped, nil
+}
+
+// relativePath is the path relative to the working directory, if it is
+// under it; for error messages
+func relativePath(file string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return file
+	}
+	relative, err := filepath.Rel(wd, file)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return file
+	}
+	return relative
 }
 
 func isDirectory(path string) (bool, error) {
@@ -112,79 +422,923 @@ func isDirectory(path string) (bool, error) {
 	return fileInfo.IsDir(), err
 }
 
-func handleFastaInput(input string) ([]sequence.Sequence, error) {
-	var files []string
-	var sequences []sequence.Sequence
+// inputData is what is read from the input; the sequences, and the
+// outgroups and character groups of the TNT files in it
+type inputData struct {
+	Sequences       []sequence.Sequence
+	Outgroups       []string
+	CharacterGroups []formats.CharacterGroup
+}
+
+// add the data read from another file
+func (d *inputData) add(more inputData) {
+	d.Sequences = append(d.Sequences, more.Sequences...)
+	for _, og := range more.Outgroups {
+		if !hasString(d.Outgroups, og) {
+			d.Outgroups = append(d.Outgroups, og)
+		}
+	}
+	d.CharacterGroups = append(d.CharacterGroups, more.CharacterGroups...)
+}
+
+// parseFunc reads one file of an input format, and returns its data.
+// The geneName is the default name of the gene; taken from the file name.
+type parseFunc func(input io.Reader, geneName string) (inputData, error)
+
+// parseFasta returns the parseFunc for FASTA; the schema (if there is
+// one) reads the species and gene from the IDs
+func parseFasta(schema *header.Schema) parseFunc {
+	return func(input io.Reader, geneName string) (inputData, error) {
+		fasta := formats.Fasta{SpeciesFromID: true, Schema: schema}
+		err := fasta.Parse(input, geneName)
+		return inputData{Sequences: fasta.Sequences}, err
+	}
+}
+
+func parseNexus(input io.Reader, geneName string) (inputData, error) {
+	nexus := formats.Nexus{}
+	err := nexus.Parse(input, geneName)
+	return inputData{Sequences: nexus.AllSequences()}, err
+}
+
+// parseTNT reads the outgroups and character groups of the file as well
+// as its sequences; none of them are kept if it can't be parsed
+func parseTNT(input io.Reader, geneName string) (inputData, error) {
+	tnt := formats.TNT{}
+	if err := tnt.Parse(input, geneName); err != nil {
+		return inputData{}, err
+	}
+	return inputData{
+		Sequences:       tnt.AllSequences(),
+		Outgroups:       tnt.Outgroups,
+		CharacterGroups: tnt.CharacterGroups,
+	}, nil
+}
+
+func hasString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// inputOutgroups are the outgroups, if any were given; otherwise the ones
+// read from TNT input which are still in the sequences (they may have been
+// renamed or filtered out)
+func inputOutgroups(outgroups, fromInput []string, sequences []sequence.Sequence) []string {
+	if len(outgroups) > 0 {
+		return outgroups
+	}
+	species := make(map[string]bool, len(sequences))
+	for _, seq := range sequences {
+		species[sequence.Safe(seq.Species)] = true
+	}
+	kept := []string{}
+	for _, og := range fromInput {
+		if species[sequence.Safe(og)] {
+			kept = append(kept, og)
+		}
+	}
+	return kept
+}
+
+// inputCharacterGroups are the character groups read from TNT input, with
+// only the genes which are still in the sequences
+func inputCharacterGroups(fromInput []formats.CharacterGroup, sequences []sequence.Sequence) []formats.CharacterGroup {
+	genes := make(map[string]bool)
+	for _, seq := range sequences {
+		genes[seq.Gene] = true
+	}
+	groups := make([]formats.CharacterGroup, 0, len(fromInput))
+	for _, group := range fromInput {
+		kept := formats.CharacterGroup{Name: group.Name, GeneCharacters: make(map[string][]int)}
+		for _, gene := range group.Genes {
+			if genes[gene] {
+				kept.Genes = append(kept.Genes, gene)
+			}
+		}
+		for gene, characters := range group.GeneCharacters {
+			if genes[gene] {
+				kept.GeneCharacters[gene] = characters
+			}
+		}
+		groups = append(groups, kept)
+	}
+	return groups
+}
+
+// parsePhylip returns the parseFunc for PHYLIP; with either strict or
+// relaxed names
+func parsePhylip(strict bool) parseFunc {
+	return func(input io.Reader, geneName string) (inputData, error) {
+		phylip := formats.Phylip{Strict: strict}
+		err := phylip.Parse(input, geneName)
+		return inputData{Sequences: phylip.AllSequences()}, err
+	}
+}
+
+// errUnknownFormat is returned by the auto parseFunc for a file that isn't
+// any of the input formats
+var errUnknownFormat = fmt.Errorf("Can't tell the format from the contents; it isn't %s, %s, %s or %s",
+	formats.FASTA_FORMAT, formats.NEXUS_FORMAT, formats.TNT_FORMAT, formats.PHYLIP_FORMAT)
 
-	if isDir, err := isDirectory(input); isDir && err == nil {
-		files, err = dirInput(input, formats.FASTA_FORMAT, true)
+// parseAuto returns the parseFunc that works out the format of each file
+// from the start of it, and reads it with that format's parseFunc
+func parseAuto(schema *header.Schema, phylipStrict bool) parseFunc {
+	return func(input io.Reader, geneName string) (inputData, error) {
+		buffered := bufio.NewReaderSize(input, formats.DETECT_SIZE)
+		head, err := buffered.Peek(formats.DETECT_SIZE)
+		if err != nil && err != io.EOF {
+			return inputData{}, err
+		}
+		switch formats.DetectFormat(head) {
+		case formats.FASTA_FORMAT:
+			return parseFasta(schema)(buffered, geneName)
+		case formats.NEXUS_FORMAT:
+			return parseNexus(buffered, geneName)
+		case formats.TNT_FORMAT:
+			return parseTNT(buffered, geneName)
+		case formats.PHYLIP_FORMAT:
+			return parsePhylip(phylipStrict)(buffered, geneName)
+		default:
+			return inputData{}, errUnknownFormat
+		}
+	}
+}
+
+// reportSkipped says which files in the input directory weren't read
+func reportSkipped(format string, skipped []string) {
+	if len(skipped) == 0 {
+		return
+	}
+	names := make([]string, len(skipped))
+	for i, file := range skipped {
+		names[i] = relativePath(file)
+	}
+	if format == formats.AUTO_FORMAT {
+		format = "a known format"
+	}
+	fmt.Fprintf(os.Stderr, "Skipped %d files that aren't %s:\n\t%s\n", len(skipped), format, strings.Join(names, "\n\t"))
+}
+
+// STDIN_GENE is the name of the gene read from stdin, if the format doesn't
+// name it
+const STDIN_GENE = "stdin"
+
+// handleFileInput reads the input file, or all the files of the format in
+// the input directory (or stdin, if it is blank, "-" or "--"), with the
+// parse function.  The files in the directory that aren't the format (and
+// with auto, the ones whose format couldn't be worked out) are skipped, and
+// reported.
+func handleFileInput(input, format string, parse parseFunc) (inputData, error) {
+	var files, skipped []string
+	var data inputData
+
+	if input == "" {
+		input = "--"
+	}
+	isDir, err := isDirectory(input)
+	if isDir && err == nil {
+		files, skipped, err = dirInput(input, format, true)
 		if err != nil {
 			// Some error in walking the directory tree
-			return nil, err
+			return inputData{}, err
 		}
+		defer func() { reportSkipped(format, skipped) }()
 	} else {
 		files = []string{input}
 	}
 
 	for _, file := range files {
-		ext := path.Ext(file)
-		geneName := filepath.Base(file[:len(file)-len(ext)])
+		uncompressed := trimCompressionExt(file)
+		ext := path.Ext(uncompressed)
+		geneName := filepath.Base(uncompressed[:len(uncompressed)-len(ext)])
+		if isStdio(file) {
+			geneName = STDIN_GENE
+		}
 		err := func() error {
-			fasta := formats.Fasta{SpeciesFromID: true}
-			fd, err := os.Open(file)
+			fd, err := getInputFilePointer(file)
 			if err != nil {
 				// probably an Access Control issue, or race condition
 				return err
 			}
 			defer fd.Close()
-			err = fasta.Parse(fd, geneName)
+			read, err := parse(fd, geneName)
+			if err == errUnknownFormat {
+				if isDir {
+					skipped = append(skipped, file)
+					return nil
+				}
+				return fmt.Errorf("%s: %s", relativePath(file), err.Error())
+			}
+			if formatErr, ok := err.(sequence.FormatError); ok {
+				// Say which file the bad data is in
+				formatErr.File = relativePath(file)
+				if isStdio(file) {
+					formatErr.File = "<stdin>"
+				}
+				return formatErr
+			}
 			if err != nil {
 				// Some parsing error...
 				return err
 			}
-			sequences = append(sequences, fasta.Sequences...)
+			data.add(read)
 			return nil
 		}()
+		if err != nil && keepParsing {
+			parseErrors = append(parseErrors, err)
+			continue
+		}
 		if err != nil {
-			return nil, err
+			return inputData{}, err
 		}
 	}
-	return sequences, nil
+	return data, nil
 }
 
-func handleFastaOutput(sequences []sequence.Sequence, output string) error {
-	fasta := formats.Fasta{}
+func handleFastaInput(input, headerSchema string) (inputData, error) {
+	var schema *header.Schema
+	if headerSchema != "" {
+		var err error
+		if schema, err = header.New(headerSchema); err != nil {
+			return inputData{}, err
+		}
+	}
+	return handleFileInput(input, formats.FASTA_FORMAT, parseFasta(schema))
+}
+
+// handleAutoInput reads the input with the format of each file worked out
+// from its contents
+func handleAutoInput(input, headerSchema string, phylipStrict bool) (inputData, error) {
+	var schema *header.Schema
+	if headerSchema != "" {
+		var err error
+		if schema, err = header.New(headerSchema); err != nil {
+			return inputData{}, err
+		}
+	}
+	return handleFileInput(input, formats.AUTO_FORMAT, parseAuto(schema, phylipStrict))
+}
+
+func handleNexusInput(input string) (inputData, error) {
+	return handleFileInput(input, formats.NEXUS_FORMAT, parseNexus)
+}
+
+func handleTNTInput(input string) (inputData, error) {
+	return handleFileInput(input, formats.TNT_FORMAT, parseTNT)
+}
+
+func handlePhylipInput(input string, strict bool) (inputData, error) {
+	return handleFileInput(input, formats.PHYLIP_FORMAT, parsePhylip(strict))
+}
+
+func handleFastaOutput(lineWidth int, sequences []sequence.Sequence, output string) error {
+	fasta := formats.Fasta{LineWidth: lineWidth}
 	fasta.AddSequence(sequences...)
-	fd, err := os.Create(output)
+	return writeOutput(output, fasta.WriteSequences)
+}
+
+// addToMatrix adds the sequences using the duplicate policy, and reports
+// every species and gene that had more than one sequence.  With the error
+// policy they are reported when the matrix is written out instead.  Then
+// the taxa and genes that don't pass the completeness filter are removed.
+func addToMatrix(matrix *formats.Matrix, sequences []sequence.Sequence) error {
+	matrix.Duplicates = duplicatePolicy
+	matrix.AddSequence(sequences...)
+	if duplicatePolicy != formats.DUPLICATE_ERROR {
+		for _, collision := range matrix.Collisions {
+			fmt.Fprintf(os.Stderr, "Duplicate sequences for %s\n", collision.String())
+		}
+	}
+	if completenessFilter == (formats.Filter{}) {
+		return nil
+	}
+	removed, err := matrix.Filter(completenessFilter)
+	for _, removal := range removed {
+		fmt.Fprintf(os.Stderr, "Filtered: %s\n", removal.String())
+	}
+	return err
+}
+
+func readCharacterCodes(filename string) ([]formats.CharacterCode, error) {
+	fd, err := os.Open(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Issue opening output file;\n%s", err.Error())
+		return nil, err
 	}
 	defer fd.Close()
-	return fasta.WriteSequences(fd)
+	return formats.ReadCharacterCodes(fd)
 }
 
 func handleTNTOutput(context TNTContext, sequences []sequence.Sequence, output string) error {
-	tnt := formats.TNT{Title: context.Title}
-	tnt.AddSequence(sequences...)
-	tnt.SetOutgroup(context.Outgroup)
-	fd, err := os.Create(output)
+	matrix := formats.Matrix{}
+	if err := addToMatrix(&matrix, sequences); err != nil {
+		return err
+	}
+	return writeTNTOutput(context, matrix, output)
+}
+
+// writeTNTOutput writes out a matrix that has already had the duplicate
+// policy and filters applied
+func writeTNTOutput(context TNTContext, matrix formats.Matrix, output string) error {
+	tnt := formats.TNT{Matrix: matrix, Title: context.Title, CodonGroups: context.CodonGroups}
+	// the matrix has the sequences which weren't filtered out
+	tnt.AddCharacterGroup(inputCharacterGroups(context.InputCharacterGroups, tnt.AllSequences())...)
+	for _, spec := range context.CharacterGroups {
+		group, err := formats.ParseCharacterGroup(spec)
+		if err != nil {
+			return err
+		}
+		tnt.AddCharacterGroup(group)
+	}
+	if context.CCodeFile != "" {
+		codes, err := readCharacterCodes(context.CCodeFile)
+		if err != nil {
+			return err
+		}
+		tnt.AddCharacterCode(codes...)
+	}
+	for _, spec := range context.CharacterCodes {
+		code, err := formats.ParseCharacterCode(spec)
+		if err != nil {
+			return err
+		}
+		tnt.AddCharacterCode(code)
+	}
+	if err := tnt.SetOutgroup(inputOutgroups(context.Outgroups, context.InputOutgroups, tnt.AllSequences())...); err != nil {
+		return err
+	}
+	if err := writeOutput(output, tnt.WriteSequences); err != nil {
+		return err
+	}
+	return handlePartitionOutput(context.Partitions, &tnt.Matrix)
+}
+
+func handleNexusOutput(context NexusContext, sequences []sequence.Sequence, output string) error {
+	matrix := formats.Matrix{}
+	if err := addToMatrix(&matrix, sequences); err != nil {
+		return err
+	}
+	return writeNexusOutput(context, matrix, output)
+}
+
+// writeNexusOutput writes out a matrix that has already had the duplicate
+// policy and filters applied
+func writeNexusOutput(context NexusContext, matrix formats.Matrix, output string) error {
+	nexus := formats.Nexus{Matrix: matrix}
+	if err := nexus.SetOutgroup(inputOutgroups(context.Outgroups, context.InputOutgroups, nexus.AllSequences())...); err != nil {
+		return err
+	}
+	if err := writeOutput(output, nexus.WriteSequences); err != nil {
+		return err
+	}
+	return handlePartitionOutput(context.Partitions, &nexus.Matrix)
+}
+
+func handlePhylipOutput(context PhylipContext, sequences []sequence.Sequence, output string) error {
+	matrix := formats.Matrix{}
+	if err := addToMatrix(&matrix, sequences); err != nil {
+		return err
+	}
+	return writePhylipOutput(context, matrix, output)
+}
+
+// writePhylipOutput writes out a matrix that has already had the duplicate
+// policy and filters applied
+func writePhylipOutput(context PhylipContext, matrix formats.Matrix, output string) error {
+	phylip := formats.Phylip{
+		Matrix:      matrix,
+		Strict:      context.Strict,
+		Interleaved: context.Interleaved,
+		LineWidth:   context.LineWidth,
+	}
+	if err := phylip.SetOutgroup(inputOutgroups(context.Outgroups, context.InputOutgroups, phylip.AllSequences())...); err != nil {
+		return err
+	}
+	if err := writeOutput(output, phylip.WriteSequences); err != nil {
+		return err
+	}
+	if err := handlePartitionOutput(context.Partitions, &phylip.Matrix); err != nil {
+		return err
+	}
+	if context.NameMap == "" {
+		return nil
+	}
+	return writeOutput(context.NameMap, phylip.WriteNameMap)
+}
+
+// outputFormats are the formats convert can write out
+var outputFormats = []string{formats.FASTA_FORMAT, formats.TNT_FORMAT, formats.NEXUS_FORMAT, formats.PHYLIP_FORMAT}
+
+// outputSpec is one of the outputs of convert; given as FORMAT:FILE
+type outputSpec struct {
+	Format string
+	File   string
+}
+
+func parseOutputSpec(spec string) (outputSpec, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return outputSpec{}, fmt.Errorf("Bad output '%s'; it must be FORMAT:FILE, eg tnt:matrix.tnt", spec)
+	}
+	for _, format := range outputFormats {
+		if parts[0] == format {
+			return outputSpec{Format: format, File: parts[1]}, nil
+		}
+	}
+	return outputSpec{}, fmt.Errorf("Unknown output format '%s' in '%s'; must be one of %s", parts[0], spec, strings.Join(outputFormats, ", "))
+}
+
+func parseOutputSpecs(specs []string) ([]outputSpec, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("convert needs at least one --out FORMAT:FILE")
+	}
+	outputs := make([]outputSpec, 0, len(specs))
+	for _, spec := range specs {
+		output, err := parseOutputSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+/*
+handleConvert writes the sequences out to all of the outputs.  The duplicate
+policy and filters are applied once, before any of them are written, so they
+all have the same taxa (and the log isn't repeated for each one); each of
+the concatenated formats is given its own copy of the filtered matrix.  The
+--outgroup is put first in all of them, so the taxa are in the same order
+too; the FASTA is ordered by gene, and then taxa.  The partition files are
+only written for the first of the concatenated formats, as they are the
+same for all of them.
+*/
+func handleConvert(context ConvertContext, sequences []sequence.Sequence, outputs []outputSpec) error {
+	matrix := formats.Matrix{}
+	if err := addToMatrix(&matrix, sequences); err != nil {
+		return err
+	}
+	if err := matrix.DuplicatesError(); err != nil {
+		return err
+	}
+	outgroups := inputOutgroups(context.TNT.Outgroups, context.TNT.InputOutgroups, matrix.AllSequences())
+	if err := matrix.SetOutgroup(outgroups...); err != nil {
+		return err
+	}
+	partitions := context.Partitions
+	for _, output := range outputs {
+		var err error
+		switch output.Format {
+		case formats.FASTA_FORMAT:
+			err = handleFastaOutput(context.LineWidth, matrix.AllSequences(), output.File)
+		case formats.TNT_FORMAT:
+			tnt := context.TNT
+			tnt.Outgroups = outgroups
+			tnt.Partitions = partitions
+			err = writeTNTOutput(tnt, matrix.Clone(), output.File)
+		case formats.NEXUS_FORMAT:
+			err = writeNexusOutput(NexusContext{Outgroups: outgroups, Partitions: partitions}, matrix.Clone(), output.File)
+		case formats.PHYLIP_FORMAT:
+			phylip := context.Phylip
+			phylip.Outgroups = outgroups
+			phylip.Partitions = partitions
+			err = writePhylipOutput(phylip, matrix.Clone(), output.File)
+		}
+		if err != nil {
+			return fmt.Errorf("Writing %s: %s", output.File, err.Error())
+		}
+		if output.Format != formats.FASTA_FORMAT {
+			partitions = PartitionContext{}
+		}
+	}
+	return nil
+}
+
+// handleValidate writes out a report of all the problems with the
+// sequences, and returns an error if there are any
+func handleValidate(asJSON, strict bool, sequences []sequence.Sequence, output string) error {
+	matrix := formats.Matrix{Duplicates: formats.DUPLICATE_FIRST}
+	matrix.AddSequence(sequences...)
+	report := matrix.Validate()
+	// the files that couldn't be read are the first problems
+	problems := make([]formats.Problem, 0, len(parseErrors)+len(report.Problems))
+	for _, err := range parseErrors {
+		problems = append(problems, formats.Problem{
+			Kind:    formats.PROBLEM_PARSE,
+			Message: strings.Replace(err.Error(), "\n", "; ", -1),
+		})
+	}
+	report.Problems = append(problems, report.Problems...)
+
+	write := report.WriteText
+	if asJSON {
+		write = report.WriteJSON
+	}
+	if err := writeOutput(output, write); err != nil {
+		return err
+	}
+	if errors := report.Errors(strict); errors > 0 {
+		return fmt.Errorf("The input has %d problems", errors)
+	}
+	return nil
+}
+
+// handleStats writes out the statistics of each gene, the concatenated
+// matrix and the taxa; as a table, CSV or JSON
+func handleStats(format string, taxa bool, sequences []sequence.Sequence, output string) error {
+	matrix := formats.Matrix{}
+	if err := addToMatrix(&matrix, sequences); err != nil {
+		return err
+	}
+	stats, err := matrix.Stats()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Issue opening output file;\n%s", err.Error())
+		return err
 	}
-	defer fd.Close()
-	return tnt.WriteSequences(fd)
+
+	var write func(io.Writer) error
+	switch format {
+	case "table":
+		write = stats.WriteTable
+	case "csv":
+		write = func(w io.Writer) error { return stats.WriteCSV(w, taxa) }
+	case "json":
+		write = stats.WriteJSON
+	default:
+		return fmt.Errorf("Unknown stats format '%s'; must be table, csv or json", format)
+	}
+	return writeOutput(output, write)
 }
 
-func parseInput(c *cli.Context) error {
+// handleOccupancy writes out which species have data for which genes; as
+// CSV or a text heatmap
+func handleOccupancy(format string, sequences []sequence.Sequence, output string) error {
+	matrix := formats.Matrix{}
+	if err := addToMatrix(&matrix, sequences); err != nil {
+		return err
+	}
+	occupancy, err := matrix.Occupancy()
+	if err != nil {
+		return err
+	}
+
+	var write func(io.Writer) error
+	switch format {
+	case "heatmap":
+		write = occupancy.WriteHeatmap
+	case "csv":
+		write = occupancy.WriteCSV
+	default:
+		return fmt.Errorf("Unknown occupancy format '%s'; must be heatmap or csv", format)
+	}
+	return writeOutput(output, write)
+}
+
+// writePartitionFile writes a partition file to the output, if there is one
+func writePartitionFile(output string, write func(io.Writer, bool) error, codonPositions bool) error {
+	if output == "" {
+		return nil
+	}
+	return writeOutput(output, func(w io.Writer) error {
+		return write(w, codonPositions)
+	})
+}
+
+// handlePartitionOutput writes out the partition files for a matrix that
+// has already been written out
+func handlePartitionOutput(context PartitionContext, matrix *formats.Matrix) error {
+	if err := writePartitionFile(context.RAxML, matrix.WriteRAxMLPartitions, context.CodonPositions); err != nil {
+		return err
+	}
+	return writePartitionFile(context.IQTree, matrix.WriteIQTreePartitions, context.CodonPositions)
+}
+
+// pipelinePathOptions are the output options which are paths; they are
+// relative to the pipeline file when it is saved
+var pipelinePathOptions = map[string]bool{
+	"raxml-partitions":  true,
+	"iqtree-partitions": true,
+	"ccode-file":        true,
+	"name-map":          true,
+}
+
+// pipelineOutput is one of the outputs of this run, with the options that
+// were given for it; flags are the options the output's format has
+func pipelineOutput(c *cli.Context, format, file string, flags []cli.Flag) pipeline.Output {
+	output := pipeline.Output{
+		Format:  format,
+		File:    file,
+		Options: make(map[string]interface{}),
+	}
+	for _, flag := range flags {
+		names := strings.Split(flag.GetName(), ",")
+		name := strings.TrimSpace(names[0])
+		set := false
+		for _, alias := range names {
+			set = set || c.IsSet(strings.TrimSpace(alias))
+		}
+		if !set {
+			continue
+		}
+		switch flag.(type) {
+		case cli.StringFlag:
+			output.Options[name] = c.String(name)
+		case cli.StringSliceFlag:
+			output.Options[name] = c.StringSlice(name)
+		case cli.IntFlag:
+			output.Options[name] = c.Int(name)
+		case cli.Float64Flag:
+			output.Options[name] = c.Float64(name)
+		case cli.BoolFlag:
+			output.Options[name] = c.Bool(name)
+		}
+	}
+	return output
+}
+
+// newPipeline is the pipeline for this run; the input and how it is read,
+// and the outputs with the options that were given.  Each of the outputs
+// of convert gets the options of its own format.
+func newPipeline(c *cli.Context, command cli.Command) (pipeline.Pipeline, error) {
+	outputs := []pipeline.Output{}
+	if command.Name == "convert" {
+		specs, err := parseOutputSpecs(c.StringSlice("out"))
+		if err != nil {
+			return pipeline.Pipeline{}, err
+		}
+		for _, spec := range specs {
+			outputs = append(outputs, pipelineOutput(c, spec.Format, spec.File, c.App.Command(spec.Format).Flags))
+		}
+	} else {
+		outputs = append(outputs, pipelineOutput(c, command.Name, c.Args().First(), command.Flags))
+	}
+	var speciesDistance *int
+	if c.GlobalIsSet("species-distance") {
+		distance := c.GlobalInt("species-distance")
+		speciesDistance = &distance
+	}
+	return pipeline.Pipeline{
+		Input:           c.GlobalString("input"),
+		InputFormat:     c.GlobalString("input-format"),
+		PhylipStrict:    c.GlobalBool("phylip-strict"),
+		HeaderSchema:    c.GlobalString("header-schema"),
+		RenameMap:       c.GlobalString("rename-map"),
+		ReverseMap:      c.GlobalString("reverse-map"),
+		MergeSpecies:    c.GlobalString("merge-species"),
+		SpeciesReport:   c.GlobalString("species-report"),
+		SpeciesDistance: speciesDistance,
+		Duplicates:      c.GlobalString("duplicates"),
+		MinGenes:        c.GlobalInt("min-genes"),
+		MinCompleteness: c.GlobalFloat64("min-completeness"),
+		MinTaxa:         c.GlobalInt("min-taxa"),
+		Outputs:         outputs,
+	}, nil
+}
+
+// savePipeline writes the pipeline for this run to the file, with the
+// paths relative to it
+func savePipeline(c *cli.Context, filename string) error {
+	run, err := newPipeline(c, c.Command)
+	if err != nil {
+		return CommandError{err, c}
+	}
+	if err := run.Relative(filepath.Dir(filename), pipelinePathOptions); err != nil {
+		return err
+	}
+	return writeOutput(filename, run.Write)
+}
+
+// optionArgs are the command line arguments for the options; a list is
+// given once for each value
+func optionArgs(options map[string]interface{}, names []string) (args []string) {
+	for _, name := range names {
+		switch value := options[name].(type) {
+		case []interface{}:
+			for _, v := range value {
+				args = append(args, fmt.Sprintf("--%s=%v", name, v))
+			}
+		case []string:
+			for _, v := range value {
+				args = append(args, fmt.Sprintf("--%s=%s", name, v))
+			}
+		default:
+			args = append(args, fmt.Sprintf("--%s=%v", name, value))
+		}
+	}
+	return
+}
+
+// convertArgs are the arguments to do several outputs with convert; they
+// share their options, so an option given differently for two of them
+// can't be done in one run
+func convertArgs(outputs []pipeline.Output) ([]string, error) {
+	args := []string{"convert"}
+	options := make(map[string]interface{})
+	names := []string{}
+	for _, output := range outputs {
+		args = append(args, fmt.Sprintf("--out=%s:%s", output.Format, output.File))
+		for _, name := range output.OptionNames() {
+			value := output.Options[name]
+			if existing, ok := options[name]; !ok {
+				options[name] = value
+				names = append(names, name)
+			} else if !reflect.DeepEqual(existing, value) {
+				return nil, fmt.Errorf("Bad pipeline: the outputs have different values for %s (%v and %v); they can't be run together",
+					name, existing, value)
+			}
+		}
+	}
+	sort.Strings(names)
+	return append(args, optionArgs(options, names)...), nil
+}
+
+// pipelineArgs are the command line arguments that do the pipeline's run;
+// one output is done with its own command, and more with convert
+func pipelineArgs(name string, run pipeline.Pipeline) ([]string, error) {
+	args := []string{
+		name,
+		"--input=" + run.Input,
+		"--header-schema=" + run.HeaderSchema,
+		"--rename-map=" + run.RenameMap,
+		"--reverse-map=" + run.ReverseMap,
+		"--merge-species=" + run.MergeSpecies,
+		"--species-report=" + run.SpeciesReport,
+		fmt.Sprintf("--min-genes=%d", run.MinGenes),
+		fmt.Sprintf("--min-completeness=%g", run.MinCompleteness),
+		fmt.Sprintf("--min-taxa=%d", run.MinTaxa),
+		fmt.Sprintf("--phylip-strict=%t", run.PhylipStrict),
+	}
+	if run.InputFormat != "" {
+		args = append(args, "--input-format="+run.InputFormat)
+	}
+	if run.Duplicates != "" {
+		args = append(args, "--duplicates="+run.Duplicates)
+	}
+	if run.SpeciesDistance != nil {
+		args = append(args, fmt.Sprintf("--species-distance=%d", *run.SpeciesDistance))
+	}
+
+	if len(run.Outputs) > 1 {
+		convert, err := convertArgs(run.Outputs)
+		return append(args, convert...), err
+	}
+	output := run.Outputs[0]
+	args = append(args, output.Format)
+	args = append(args, optionArgs(output.Options, output.OptionNames())...)
+	if output.File != "" {
+		args = append(args, output.File)
+	}
+	return args, nil
+}
+
+// runPipeline reads the pipeline file, and does its run again
+func runPipeline(c *cli.Context) error {
+	filename := c.Args().First()
+	if filename == "" {
+		return CommandError{fmt.Errorf("run needs a PIPELINE_FILE"), c}
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	run, err := pipeline.Read(fd)
+	fd.Close()
+	if err != nil {
+		return err
+	}
+	run.Resolve(filepath.Dir(filename), pipelinePathOptions)
+	args, err := pipelineArgs(c.App.Name, run)
+	if err != nil {
+		return err
+	}
+	return c.App.Run(args)
+}
+
+// withInput reads the input before doing the command's action.  This isn't
+// done in the command's Before, as cli writes the errors from it to stdout
+// (with the help); where they would end up in the output of a pipeline.
+func withInput(action func(*cli.Context, inputData) error) func(*cli.Context) error {
+	return func(c *cli.Context) error {
+		input, err := parseInput(c)
+		if err != nil {
+			return err
+		}
+		return action(c, input)
+	}
+}
+
+func parseInput(c *cli.Context) (inputData, error) {
 	var err error
-	var inputFormat string = c.String("intput-format")
+	var input inputData
+	if filename := c.GlobalString("save-pipeline"); filename != "" {
+		if err = savePipeline(c, filename); err != nil {
+			return input, err
+		}
+	}
+	var inputFormat string = c.GlobalString("input-format")
+	if duplicatePolicy, err = formats.ParseDuplicatePolicy(c.GlobalString("duplicates")); err != nil {
+		return input, CommandError{err, c}
+	}
+	completenessFilter = formats.Filter{
+		MinGenes:        c.GlobalInt("min-genes"),
+		MinCompleteness: c.GlobalFloat64("min-completeness"),
+		MinTaxa:         c.GlobalInt("min-taxa"),
+	}
+	if completenessFilter.MinCompleteness < 0 || completenessFilter.MinCompleteness > 1 {
+		return input, CommandError{fmt.Errorf("--min-completeness must be between 0 and 1, got %g", completenessFilter.MinCompleteness), c}
+	}
 	switch inputFormat {
 	case formats.FASTA_FORMAT:
-		sequences, err = handleFastaInput(c.String("input"))
+		input, err = handleFastaInput(c.GlobalString("input"), c.GlobalString("header-schema"))
+	case formats.NEXUS_FORMAT:
+		input, err = handleNexusInput(c.GlobalString("input"))
+	case formats.TNT_FORMAT:
+		input, err = handleTNTInput(c.GlobalString("input"))
+	case formats.PHYLIP_FORMAT:
+		input, err = handlePhylipInput(c.GlobalString("input"), c.GlobalBool("phylip-strict"))
+	case formats.AUTO_FORMAT:
+		input, err = handleAutoInput(c.GlobalString("input"), c.GlobalString("header-schema"), c.GlobalBool("phylip-strict"))
 	default:
 		err = CommandError{fmt.Errorf("Unknown intput format '%s'", inputFormat), c}
 	}
-	return err
+	if err != nil {
+		return input, err
+	}
+	if err = renameTaxa(input.Sequences, c.GlobalString("rename-map"), c.GlobalString("reverse-map")); err != nil {
+		return input, err
+	}
+	return input, reconcileSpecies(input.Sequences, c.GlobalString("merge-species"), c.GlobalString("species-report"), c.GlobalInt("species-distance"))
+}
+
+// renameComma is the column separator for a rename map; comma for .csv
+// files (compressed or not), otherwise tab
+func renameComma(filename string) rune {
+	if strings.ToLower(filepath.Ext(trimCompressionExt(filename))) == ".csv" {
+		return ','
+	}
+	return '\t'
+}
+
+// renameTaxa renames the sequences using the rename map, reports the names
+// that weren't in it and the entries that weren't used, and writes out the
+// reverse map
+func renameTaxa(sequences []sequence.Sequence, mapFile, reverseFile string) error {
+	if mapFile == "" {
+		if reverseFile != "" {
+			return fmt.Errorf("--reverse-map needs a --rename-map")
+		}
+		return nil
+	}
+	fd, err := os.Open(mapFile)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	renames, err := taxa.ReadRenameMap(fd, renameComma(mapFile))
+	if err != nil {
+		return err
+	}
+	if unmapped := renames.Rename(sequences); len(unmapped) > 0 {
+		fmt.Fprintf(os.Stderr, "%d names aren't in %s:\n\t%s\n", len(unmapped), mapFile, strings.Join(unmapped, "\n\t"))
+	}
+	if unused := renames.Unused(); len(unused) > 0 {
+		fmt.Fprintf(os.Stderr, "%d entries in %s weren't used:\n\t%s\n", len(unused), mapFile, strings.Join(unused, "\n\t"))
+	}
+	if reverseFile == "" {
+		return nil
+	}
+	renames.Comma = renameComma(reverseFile)
+	return writeOutput(reverseFile, renames.WriteReverse)
+}
+
+// reconcileSpecies merges the species using the mapping file, and then
+// writes out a report of the species that still look like duplicates
+func reconcileSpecies(sequences []sequence.Sequence, mappingFile, reportFile string, maxDistance int) error {
+	if mappingFile != "" {
+		fd, err := os.Open(mappingFile)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		mapping, err := taxa.ReadMapping(fd)
+		if err != nil {
+			return err
+		}
+		merged := mapping.Merge(sequences)
+		fmt.Fprintf(os.Stderr, "Merged the species of %d sequences using %s\n", merged, mappingFile)
+	}
+	if reportFile == "" {
+		return nil
+	}
+	clusters := taxa.Reconcile(sequences, maxDistance)
+	if len(clusters) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d species that look like duplicates; see %s\n", len(clusters), reportFile)
+	}
+	return writeOutput(reportFile, func(w io.Writer) error {
+		return taxa.WriteReport(w, clusters)
+	})
 }
 
 func main() {
@@ -192,7 +1346,7 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "refasta"
 	app.Usage = "Convert various genitics data formats into other formats. " +
-		"Currently only fasta and tnt are supported, and in an opinionated way.\n\n" +
+		"Currently only fasta, tnt, nexus and phylip are supported, and in an opinionated way.\n\n" +
 		"    To see the options for an output file type, run\n\n" +
 		"        refasta help <filetype>\n\n" +
 		"    For Example\n\n" +
@@ -202,12 +1356,80 @@ func main() {
 		cli.StringFlag{
 			Name:  "input, i",
 			Value: "",
-			Usage: "`INPUT`, it must be either a file or directory.  If blank, stdin will be used",
+			Usage: "`INPUT`, it must be either a file or directory.  If blank, - or --, stdin will be used (in any of the formats, " +
+				"compressed or not); eg 'refasta fasta < in.fas > out.fas'",
 		},
 		cli.StringFlag{
 			Name:  "input-format, f",
 			Value: formats.FASTA_FORMAT,
-			Usage: "`INPUT_FORMAT` must be one of the supported input types. Currently only 'fasta' is supported",
+			Usage: "`INPUT_FORMAT` must be one of the supported input types. Currently 'fasta', 'nexus', 'tnt' and 'phylip' are supported; " +
+				"or 'auto', to work out the format of each file from its contents (a leading '>', #NEXUS, xread, or a PHYLIP header)",
+		},
+		cli.StringFlag{
+			Name:  "header-schema",
+			Value: "",
+			Usage: "`SCHEMA` for reading the species and gene from FASTA IDs; either a preset ('" +
+				strings.Join(header.PresetNames(), "', '") + "'), or a regular expression with named captures, " +
+				"eg '(?P<species>[^|]+)\\|(?P<gene>.+)'.  species and gene set the species and gene; any other captures are kept " +
+				"as extra fields.  If blank, the whole ID is the species",
+		},
+		cli.StringFlag{
+			Name:  "rename-map",
+			Value: "",
+			Usage: "CSV (.csv) or tab separated `FILE` of names (eg voucher codes) and the names to change them to (eg the accepted species). " +
+				"Sequences are matched on their ID, header fields, or species; lines starting with '#' are skipped",
+		},
+		cli.StringFlag{
+			Name:  "reverse-map",
+			Value: "",
+			Usage: "`FILE` to write the --rename-map out the other way around, so the original names can be restored later with --rename-map",
+		},
+		cli.StringFlag{
+			Name:  "species-report",
+			Value: "",
+			Usage: "`FILE` to write a report of species names that look like the same species (eg 'Homo  sapiens' and 'Homo_sapien'). " +
+				"It is a tab separated mapping of the names to merge; check it, and pass it back with --merge-species",
+		},
+		cli.StringFlag{
+			Name:  "merge-species",
+			Value: "",
+			Usage: "Tab separated mapping `FILE` of species names in the input, and the name to merge them into; as written by --species-report",
+		},
+		cli.IntFlag{
+			Name:  "species-distance",
+			Value: taxa.MAX_DISTANCE,
+			Usage: "Largest number of letters, `DISTANCE`, two species names can differ by and be reported as the same species",
+		},
+		cli.StringFlag{
+			Name:  "duplicates",
+			Value: "error",
+			Usage: "`POLICY` for more than one sequence for the same species and gene in the tnt, nexus and phylip outputs; one of " +
+				strings.Join(formats.DuplicatePolicyNames(), ", ") + ".  'complete' keeps the one with the fewest gaps, and 'consensus' " +
+				"merges them, with the differences as polymorphisms (eg [AG])",
+		},
+		cli.IntFlag{
+			Name:  "min-genes",
+			Usage: "Remove the taxa with data for fewer than `N` genes from the tnt, nexus and phylip outputs (and the stats and occupancy)",
+		},
+		cli.Float64Flag{
+			Name: "min-completeness",
+			Usage: "Remove the taxa with data for less than `FRACTION` (0 to 1) of the concatenated characters; gaps and missing data " +
+				"don't count",
+		},
+		cli.IntFlag{
+			Name: "min-taxa",
+			Usage: "Remove the genes with data for fewer than `N` taxa.  Removing taxa and genes is repeated until every one that " +
+				"is left passes, and everything removed is logged",
+		},
+		cli.BoolFlag{
+			Name:  "phylip-strict",
+			Usage: "Read PHYLIP input with strict names; the first 10 characters of each line.  Otherwise names end at the first space",
+		},
+		cli.StringFlag{
+			Name:  "save-pipeline",
+			Value: "",
+			Usage: "`FILE` to save this run to as a YAML pipeline; the input, how it is read, the renames, filters and output, " +
+				"with the output's options.  Do it again with 'refasta run FILE'",
 		},
 	}
 
@@ -218,10 +1440,17 @@ func main() {
 			UsageText:   "This will convert the input to a fasta formatted file.",
 			Description: "This requires an input file or directory, and an input format.  If you do not specify an OUTPUT_FILE, then the output will be written to stdout",
 			ArgsUsage:   "[OUTPUT_FILE]",
-			Before:      parseInput,
-			Action: func(c *cli.Context) error {
-				return handleFastaOutput(sequences, c.Args().First())
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "line-width",
+					Value: 0,
+					Usage: "Wrap the sequences onto lines of `WIDTH` characters (eg 60, 70 or 80).  Polymorphisms ([AG]) are never split. " +
+						"0 writes each sequence on one line",
+				},
 			},
+			Action: withInput(func(c *cli.Context, input inputData) error {
+				return handleFastaOutput(c.Int("line-width"), input.Sequences, c.Args().First())
+			}),
 		},
 		cli.Command{
 			Name:        "tnt",
@@ -229,29 +1458,139 @@ func main() {
 			UsageText:   "This will convert the input to a TNT formatted file.",
 			Description: "This requires an input file or directory, and an input format.  You can specify the outgroup and title of the file.  If you do not specify an OUTPUT_FILE, then the output will be written to stdout",
 			ArgsUsage:   "[OUTPUT_FILE]",
-			Before:      parseInput,
+			Flags:       append(tntFlags, partitionFlags...),
+			Action: withInput(func(c *cli.Context, input inputData) error {
+				fmt.Fprintf(os.Stderr, "Output format is TNT; serializing\n")
+				return handleTNTOutput(newTNTContext(c, input), input.Sequences, c.Args().First())
+			}),
+		},
+		cli.Command{
+			Name:        "phylip",
+			Usage:       "Convert to `PHYLIP` format",
+			UsageText:   "This will convert the input to a PHYLIP formatted file, for RAxML, IQ-TREE etc.",
+			Description: "This requires an input file or directory, and an input format.  Names are relaxed (full length) unless --strict is given.  If you do not specify an OUTPUT_FILE, then the output will be written to stdout",
+			ArgsUsage:   "[OUTPUT_FILE]",
+			Flags: append(append(phylipFlags, cli.IntFlag{
+				Name:  "line-width",
+				Value: formats.PHYLIP_LINE_WIDTH,
+				Usage: "Number of characters per line, `WIDTH`, when interleaved",
+			}), partitionFlags...),
+			Action: withInput(func(c *cli.Context, input inputData) error {
+				return handlePhylipOutput(newPhylipContext(c, input), input.Sequences, c.Args().First())
+			}),
+		},
+		cli.Command{
+			Name:        "nexus",
+			Usage:       "Convert to `NEXUS` format",
+			UsageText:   "This will convert the input to a NEXUS formatted file, with a charset for each gene.",
+			Description: "This requires an input file or directory, and an input format.  If you do not specify an OUTPUT_FILE, then the output will be written to stdout",
+			ArgsUsage:   "[OUTPUT_FILE]",
+			Flags:       partitionFlags,
+			Action: withInput(func(c *cli.Context, input inputData) error {
+				context := NexusContext{Partitions: newPartitionContext(c), InputOutgroups: input.Outgroups}
+				return handleNexusOutput(context, input.Sequences, c.Args().First())
+			}),
+		},
+		cli.Command{
+			Name:        "validate",
+			Usage:       "Check the input, and report every problem found",
+			UsageText:   "This will check the input for problems, and report them all at once instead of failing at the first one.",
+			Description: "This requires an input file or directory, and an input format.  It reports files which can't be parsed (and carries on with the others), sequences of different lengths in a gene, unknown characters, empty genes, species missing from genes (a warning), genes with more than one type of sequence, and more than one sequence for a species and gene.  It exits with an error if any problems are found.  If you do not specify an OUTPUT_FILE, then the report will be written to stdout",
+			ArgsUsage:   "[OUTPUT_FILE]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Write the report as JSON",
+				},
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "Exit with an error for warnings as well",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				keepParsing = true
+				return withInput(func(c *cli.Context, input inputData) error {
+					return handleValidate(c.Bool("json"), c.Bool("strict"), input.Sequences, c.Args().First())
+				})(c)
+			},
+		},
+		cli.Command{
+			Name:        "stats",
+			Usage:       "Report the statistics of each gene, and the concatenated matrix",
+			UsageText:   "This will report the number of taxa, length, variable and parsimony informative sites, GC content and missing data of each gene and the concatenated matrix, and how complete each taxon is.",
+			Description: "This requires an input file or directory, and an input format.  Gaps, missing data, polymorphisms and ambiguity codes are not counted as states for the variable and informative sites.  If you do not specify an OUTPUT_FILE, then the stats will be written to stdout",
+			ArgsUsage:   "[OUTPUT_FILE]",
 			Flags: []cli.Flag{
 				cli.StringFlag{
-					Name:  "outgroup",
-					Value: "",
-					Usage: "Optional `OUTGROUP` for TNT output.  If specified, this species will be used as the outgroup for TNT. " +
-						"Otherwise the first (alphabetically) will be used.  This must be left blank, or be a valid species name " +
-						"from the input",
+					Name:  "format",
+					Value: "table",
+					Usage: "`FORMAT` of the stats; table, csv or json",
+				},
+				cli.BoolFlag{
+					Name:  "taxa",
+					Usage: "Write the completeness of each taxon instead of the gene stats, for csv",
 				},
+			},
+			Action: withInput(func(c *cli.Context, input inputData) error {
+				return handleStats(c.String("format"), c.Bool("taxa"), input.Sequences, c.Args().First())
+			}),
+		},
+		cli.Command{
+			Name:        "occupancy",
+			Usage:       "Report which species have data for which genes",
+			UsageText:   "This will report the coverage of each gene by each species, before the missing genes are filled in with gaps.",
+			Description: "This requires an input file or directory, and an input format.  The coverage is the fraction of a gene's characters that a species has data for; 0 if it doesn't have the gene.  If you do not specify an OUTPUT_FILE, then the report will be written to stdout",
+			ArgsUsage:   "[OUTPUT_FILE]",
+			Flags: []cli.Flag{
 				cli.StringFlag{
-					Name:  "tnt-title, t",
-					Value: "",
-					Usage: "`TITLE` for TNT output",
+					Name:  "format",
+					Value: "heatmap",
+					Usage: "`FORMAT` of the report; heatmap (text) or csv",
 				},
 			},
-			Action: func(c *cli.Context) error {
-				fmt.Fprintf(os.Stderr, "Output format is TNT; serializing\n")
-				context := TNTContext{
-					Title:    c.String("title"),
-					Outgroup: c.String("outgroup"),
+			Action: withInput(func(c *cli.Context, input inputData) error {
+				return handleOccupancy(c.String("format"), input.Sequences, c.Args().First())
+			}),
+		},
+		cli.Command{
+			Name:        "convert",
+			Usage:       "Convert to several formats at once",
+			UsageText:   "This will convert the input to all the formats given with --out, reading it only once.",
+			Description: "This requires an input file or directory, and an input format.  It takes the options of all the formats it writes out.  The duplicate policy and filters are applied once, so every output has the same taxa in the same order; the FASTA output is ordered by gene and then species, the same as the others",
+			Flags: append(append(append([]cli.Flag{
+				cli.StringSliceFlag{
+					Name: "out",
+					Usage: "`FORMAT:FILE` to write out; eg tnt:matrix.tnt.  The format is one of " + strings.Join(outputFormats, ", ") +
+						".  Give it more than once for more outputs",
+				},
+				cli.IntFlag{
+					Name:  "line-width",
+					Value: 0,
+					Usage: "Wrap the FASTA sequences, and the interleaved PHYLIP data, onto lines of `WIDTH` characters. " +
+						"0 writes each FASTA sequence on one line, and the PHYLIP data " + fmt.Sprint(formats.PHYLIP_LINE_WIDTH) + " characters to a line",
+				},
+			}, tntFlags...), phylipFlags...), partitionFlags...),
+			Action: withInput(func(c *cli.Context, input inputData) error {
+				outputs, err := parseOutputSpecs(c.StringSlice("out"))
+				if err != nil {
+					return CommandError{err, c}
 				}
-				return handleTNTOutput(context, sequences, c.Args().First())
-			},
+				context := ConvertContext{
+					LineWidth:  c.Int("line-width"),
+					TNT:        newTNTContext(c, input),
+					Phylip:     newPhylipContext(c, input),
+					Partitions: newPartitionContext(c),
+				}
+				return handleConvert(context, input.Sequences, outputs)
+			}),
+		},
+		cli.Command{
+			Name:        "run",
+			Usage:       "Run a pipeline saved with --save-pipeline",
+			UsageText:   "This will do a run saved to a YAML pipeline file with --save-pipeline again.",
+			Description: "The pipeline has the input, how it is read, the renames, filters and output, with the output's options; any other flags given are ignored.  Relative paths in it are relative to the pipeline file",
+			ArgsUsage:   "PIPELINE_FILE",
+			Action:      runPipeline,
 		},
 	}
 
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yarbelk/refasta/formats"
+	"github.com/yarbelk/refasta/pipeline"
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func testSequence(species, gene, data string) sequence.Sequence {
+	seq := sequence.NewSequence(species, []byte(data))
+	seq.Species = species
+	seq.Gene = gene
+	return seq
+}
+
+// taxaOrder is the order the species are first written out in; a taxon
+// is on a line of its own, with its name (or >name) first
+func taxaOrder(t *testing.T, file string, species []string) []string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Expected no error reading %s, got %v", file, err)
+	}
+	order := []string{}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), ">"))
+		if len(fields) == 0 {
+			continue
+		}
+		for _, name := range species {
+			if fields[0] == sequence.Safe(name) && !seen[name] {
+				order = append(order, name)
+				seen[name] = true
+			}
+		}
+	}
+	return order
+}
+
+func TestConvertOutputsHaveTheSameTaxaOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	seqs := []sequence.Sequence{
+		testSequence("Homo sapiens", "ATP8", "ATAGCTAG"),
+		testSequence("Pan troglodytes", "ATP8", "ATAGCTAC"),
+		testSequence("Gorilla gorilla", "ATP8", "ATAGCTCC"),
+		testSequence("Homo sapiens", "ATP6", "TAGCAT"),
+		testSequence("Pan troglodytes", "ATP6", "TAGCAA"),
+		testSequence("Gorilla gorilla", "ATP6", "TAGCCA"),
+	}
+	outputs := []outputSpec{
+		{Format: "tnt", File: filepath.Join(dir, "matrix.tnt")},
+		{Format: "phylip", File: filepath.Join(dir, "matrix.phy")},
+		{Format: "nexus", File: filepath.Join(dir, "matrix.nex")},
+		{Format: "fasta", File: filepath.Join(dir, "concat.fas")},
+	}
+	context := ConvertContext{TNT: TNTContext{Outgroups: []string{"Pan troglodytes", "Gorilla gorilla"}}}
+	if err := handleConvert(context, seqs, outputs); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	species := []string{"Homo sapiens", "Pan troglodytes", "Gorilla gorilla"}
+	expected := []string{"Pan troglodytes", "Gorilla gorilla", "Homo sapiens"}
+	for _, output := range outputs {
+		if order := taxaOrder(t, output.File, species); !reflect.DeepEqual(order, expected) {
+			t.Errorf("Expected the %s taxa in the order %v, got %v", output.Format, expected, order)
+		}
+	}
+}
+
+func TestConvertWritesEachFormatsMissingData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	seqs := []sequence.Sequence{
+		testSequence("Homo sapiens", "ATP8", "ATAG"),
+		testSequence("Pan troglodytes", "ATP8", "ATAC"),
+		testSequence("Homo sapiens", "ATP6", "TAGC"),
+	}
+	outputs := []outputSpec{
+		{Format: "tnt", File: filepath.Join(dir, "matrix.tnt")},
+		{Format: "nexus", File: filepath.Join(dir, "matrix.nex")},
+	}
+	if err := handleConvert(ConvertContext{}, seqs, outputs); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	missing := map[string]string{"tnt": "----ATAC", "nexus": "????ATAC"}
+	for _, output := range outputs {
+		data, err := ioutil.ReadFile(output.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "Pan_troglodytes "+missing[output.Format]) {
+			t.Errorf("Expected the %s to have Pan_troglodytes %s, got\n%s", output.Format, missing[output.Format], data)
+		}
+	}
+}
+
+func TestTNTRoundTripKeepsGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := `xread
+14 3
+Homo_sapiens ATAGCTAGTAGCAT
+Pan_troglodytes ATAGCTACTAGCAA
+Gorilla_gorilla ATAGCTCCTAGCCA
+;
+blocks 0 8;
+cnames
+[1 ATP8;
+[2 ATP6;
+;
+xgroup
+=0 (start) 0.2 8.10
+;
+agroup =0 (outgroup) 2 1;
+outgroup 2;`
+	read, err := parseTNT(strings.NewReader(input), "default")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	output := filepath.Join(dir, "matrix.tnt")
+	context := TNTContext{InputOutgroups: read.Outgroups, InputCharacterGroups: read.CharacterGroups}
+	if err := handleTNTOutput(context, read.Sequences, output); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ATP6 is written out first, so the start group moves with the genes
+	for _, expected := range []string{"=0 (start) 0.2 6.8\n", "agroup =0 (outgroup) 0 1;", "outgroup 0;"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected the output to have '%s', got:\n%s", expected, data)
+		}
+	}
+	if order := taxaOrder(t, output, []string{"Homo sapiens", "Pan troglodytes", "Gorilla gorilla"}); !reflect.DeepEqual(order, []string{"Gorilla gorilla", "Pan troglodytes", "Homo sapiens"}) {
+		t.Errorf("Expected the outgroups first, got %v", order)
+	}
+}
+
+func TestTNTThatCantBeParsedKeepsNoGroups(t *testing.T) {
+	input := `xread
+8 2
+Homo_sapiens ATAGCTAG
+;
+outgroup 0;`
+	read, err := parseTNT(strings.NewReader(input), "default")
+	if err == nil {
+		t.Fatalf("Expected an error for the missing taxon")
+	}
+	if len(read.Sequences) != 0 || len(read.Outgroups) != 0 || len(read.CharacterGroups) != 0 {
+		t.Errorf("Expected nothing to be kept, got %+v", read)
+	}
+}
+
+func TestValidateReportsParseErrors(t *testing.T) {
+	keepParsing = true
+	defer func() { keepParsing, parseErrors = false, nil }()
+	dir, err := ioutil.TempDir("", "refasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"ATP6.fasta": ">Homo_sapiens\nAT*C\n",
+		"ATP8.fasta": ">Homo_sapiens\nATGC\n>Pan_troglodytes\nATGCA\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read, err := handleFastaInput(dir, "")
+	seqs := read.Sequences
+	if err != nil {
+		t.Fatalf("Expected the bad file to be collected, got %v", err)
+	}
+	if len(seqs) != 2 || len(parseErrors) != 1 {
+		t.Fatalf("Expected 2 sequences and 1 parse error, got %d and %v", len(seqs), parseErrors)
+	}
+
+	output := filepath.Join(dir, "report.txt")
+	if err := handleValidate(false, false, seqs, output); err == nil {
+		t.Errorf("Expected the problems to be an error")
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !strings.HasPrefix(lines[0], "parse: ") || !strings.Contains(lines[0], "ATP6.fasta:2:3:") ||
+		!strings.HasPrefix(lines[1], "length: ATP8") || lines[len(lines)-1] != "1 genes, 2 species, 2 problems (0 warnings)" {
+		t.Errorf("Expected the parse error and the length problem, got:\n%s", data)
+	}
+}
+
+const testFasta = ">Homo_sapiens\nATAGCTAG\n"
+
+// bzip2Fasta is testFasta compressed with bzip2, which Go can only read
+var bzip2Fasta = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x43, 0xbe, 0x6f, 0x6d, 0x00, 0x00,
+	0x01, 0x4f, 0x80, 0x00, 0x10, 0x00, 0x01, 0x28, 0xc0, 0x04, 0x00, 0xa2, 0x23, 0xc8, 0x00, 0x20,
+	0x00, 0x31, 0x4c, 0x00, 0x13, 0x42, 0x26, 0x26, 0x9a, 0x68, 0xd3, 0xca, 0x64, 0xb4, 0xa2, 0xbc,
+	0x24, 0x69, 0x84, 0xd9, 0x7f, 0x0b, 0x04, 0xfc, 0x5d, 0xc9, 0x14, 0xe1, 0x42, 0x41, 0x0e, 0xf9,
+	0xbd, 0xb4,
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withStdio runs f with stdin reading input, and returns what it wrote to
+// stdout; os.Stdin and os.Stdout have to be files, so they are in dir
+func withStdio(t *testing.T, dir string, input []byte, f func()) []byte {
+	if err := ioutil.WriteFile(filepath.Join(dir, "stdin"), input, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStdin, oldStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	defer func() { os.Stdin, os.Stdout = oldStdin, oldStdout }()
+	f()
+	stdout.Close()
+
+	output, err := ioutil.ReadFile(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func TestDecompress(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		input []byte
+	}{
+		{"plain", []byte(testFasta)},
+		{"gzip", gzipped(t, testFasta)},
+		{"bzip2", bzip2Fasta},
+	} {
+		reader, err := decompress(bytes.NewReader(test.input))
+		if err != nil {
+			t.Errorf("Expected no error for %s, got %v", test.name, err)
+			continue
+		}
+		if data, err := ioutil.ReadAll(reader); err != nil || string(data) != testFasta {
+			t.Errorf("Expected %s to read as %q, got %q (%v)", test.name, testFasta, data, err)
+		}
+	}
+
+	// Shorter than the magic bytes, or empty, is read as it is
+	for _, input := range []string{"", ">"} {
+		reader, err := decompress(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", input, err)
+		}
+		if data, _ := ioutil.ReadAll(reader); string(data) != input {
+			t.Errorf("Expected %q back, got %q", input, data)
+		}
+	}
+	if _, err := decompress(bytes.NewReader(gzipMagic)); err == nil {
+		t.Errorf("Expected an error for a truncated gzip header")
+	}
+}
+
+func TestGetInputFilePointer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The compression is worked out from the data, not the name
+	for _, test := range []struct {
+		file string
+		data []byte
+	}{
+		{"plain.fasta", []byte(testFasta)},
+		{"gzip.fasta.gz", gzipped(t, testFasta)},
+		{"misnamed.fasta", gzipped(t, testFasta)},
+		{"bzip2.fasta.bz2", bzip2Fasta},
+		{"misnamed.fasta.gz", bzip2Fasta},
+	} {
+		file := filepath.Join(dir, test.file)
+		if err := ioutil.WriteFile(file, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fd, err := getInputFilePointer(file)
+		if err != nil {
+			t.Errorf("Expected no error opening %s, got %v", test.file, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(fd)
+		fd.Close()
+		if err != nil || string(data) != testFasta {
+			t.Errorf("Expected %s to read as %q, got %q (%v)", test.file, testFasta, data, err)
+		}
+	}
+
+	if _, err := getInputFilePointer(filepath.Join(dir, "missing.fasta")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+
+	for _, stdin := range []string{"-", "--"} {
+		var data []byte
+		withStdio(t, dir, gzipped(t, testFasta), func() {
+			fd, err := getInputFilePointer(stdin)
+			if err != nil {
+				t.Fatalf("Expected no error opening %s, got %v", stdin, err)
+			}
+			defer fd.Close()
+			data, _ = ioutil.ReadAll(fd)
+		})
+		if string(data) != testFasta {
+			t.Errorf("Expected %s to read stdin as %q, got %q", stdin, testFasta, data)
+		}
+	}
+}
+
+func TestReadFromStdin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, input := range []string{"", "-", "--"} {
+		var read inputData
+		withStdio(t, dir, bzip2Fasta, func() {
+			if read, err = handleFileInput(input, formats.AUTO_FORMAT, parseAuto(nil, false)); err != nil {
+				t.Fatalf("Expected no error reading %q, got %v", input, err)
+			}
+		})
+		seqs := read.Sequences
+		if len(seqs) != 1 || seqs[0].Gene != STDIN_GENE || string(seqs[0].Seq) != "ATAGCTAG" {
+			t.Errorf("Expected %q to read the %s gene from stdin, got %v", input, STDIN_GENE, seqs)
+		}
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(w io.Writer) error {
+		_, err := io.WriteString(w, testFasta)
+		return err
+	}
+
+	for _, test := range []struct {
+		file       string
+		compressed bool
+	}{
+		{"plain.fasta", false},
+		{"compressed.fasta.gz", true},
+		{"COMPRESSED.FAS.GZ", true},
+		{"plain.fasta.bz2", false},
+	} {
+		file := filepath.Join(dir, test.file)
+		if err := writeOutput(file, write); err != nil {
+			t.Errorf("Expected no error writing %s, got %v", test.file, err)
+			continue
+		}
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(raw, gzipMagic) != test.compressed {
+			t.Errorf("Expected %s to be compressed: %v, got %q", test.file, test.compressed, raw)
+		}
+		fd, err := getInputFilePointer(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(fd)
+		fd.Close()
+		if err != nil || string(data) != testFasta {
+			t.Errorf("Expected %s to read back as %q, got %q (%v)", test.file, testFasta, data, err)
+		}
+	}
+
+	if err := writeOutput(filepath.Join(dir, "missing", "out.fasta"), write); err == nil {
+		t.Errorf("Expected an error writing to a missing directory")
+	}
+
+	for _, stdout := range []string{"", "-", "--"} {
+		output := withStdio(t, dir, nil, func() {
+			if err := writeOutput(stdout, write); err != nil {
+				t.Fatalf("Expected no error writing %q, got %v", stdout, err)
+			}
+		})
+		if string(output) != testFasta {
+			t.Errorf("Expected %q to write %q to stdout, got %q", stdout, testFasta, output)
+		}
+	}
+}
+
+// closeRecorder records that it has been closed
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestWriteClosers(t *testing.T) {
+	file := &closeRecorder{}
+	gz := gzipWriteCloser{gzip.NewWriter(file), file}
+	if _, err := io.WriteString(gz, testFasta); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !file.closed {
+		t.Errorf("Expected closing the gzip writer to close the file")
+	}
+	reader, err := gzip.NewReader(&file.Buffer)
+	if err != nil {
+		t.Fatalf("Expected a finished gzip stream, got %v", err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != testFasta {
+		t.Errorf("Expected the gzip stream to read back as %q, got %q (%v)", testFasta, data, err)
+	}
+
+	out := &closeRecorder{}
+	fake := FakeWriteCloser{bufio.NewWriter(out)}
+	if _, err := io.WriteString(fake, testFasta); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected the writes to be buffered, got %q", out.String())
+	}
+	if err := fake.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if out.String() != testFasta || out.closed {
+		t.Errorf("Expected closing to flush %q and leave it open, got %q (closed: %v)", testFasta, out.String(), out.closed)
+	}
+}
+
+func TestPipelineArgsOnlyPassTheInputFormatIfSet(t *testing.T) {
+	run := pipeline.Pipeline{Input: "genes", Outputs: []pipeline.Output{{Format: "tnt"}}}
+	args, err := pipelineArgs("refasta", run)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--input-format") {
+			t.Errorf("Expected the default input format, got %s", arg)
+		}
+	}
+}
+
+func TestPipelineArgsOnlyPassTheSpeciesDistanceIfSet(t *testing.T) {
+	run := pipeline.Pipeline{Input: "genes", InputFormat: "fasta", Outputs: []pipeline.Output{{Format: "tnt"}}}
+	args, err := pipelineArgs("refasta", run)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--species-distance") {
+			t.Errorf("Expected the default species distance, got %s", arg)
+		}
+	}
+
+	distance := 0
+	run.SpeciesDistance = &distance
+	if args, err = pipelineArgs("refasta", run); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	found := false
+	for _, arg := range args {
+		found = found || arg == "--species-distance=0"
+	}
+	if !found {
+		t.Errorf("Expected --species-distance=0 in %v", args)
+	}
+}
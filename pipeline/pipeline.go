@@ -0,0 +1,164 @@
+/*
+Package pipeline saves a run of refasta, so it can be done again; the
+input and how it is read, the renames and filters, and the outputs with
+their options.  Pipelines are YAML files:
+
+	input: genes
+	input_format: fasta
+	header_schema: ncbi
+	species_distance: 2
+	min_taxa: 4
+	outputs:
+	- format: tnt
+	  file: matrix.tnt
+	  options:
+	    outgroup:
+	    - Pan troglodytes
+	    tnt-title: primates
+
+Relative paths in a pipeline are relative to the pipeline file, not to
+where refasta is run from.
+*/
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Output is one of the formats (or reports) the run writes out
+type Output struct {
+	// Format is the output format or report; eg tnt or stats
+	Format string `yaml:"format"`
+	// File is written to; blank is stdout
+	File string `yaml:"file,omitempty"`
+	// Options are the format's own options by name; eg outgroup, tnt-title
+	Options map[string]interface{} `yaml:"options,omitempty"`
+}
+
+// Pipeline is everything needed to do a run again.  SpeciesDistance is
+// only set if it was given, as 0 is a distance; without it, the default is
+// used.  The default is used for a blank InputFormat too.
+type Pipeline struct {
+	Input           string  `yaml:"input"`
+	InputFormat     string  `yaml:"input_format"`
+	PhylipStrict    bool    `yaml:"phylip_strict,omitempty"`
+	HeaderSchema    string  `yaml:"header_schema,omitempty"`
+	RenameMap       string  `yaml:"rename_map,omitempty"`
+	ReverseMap      string  `yaml:"reverse_map,omitempty"`
+	MergeSpecies    string  `yaml:"merge_species,omitempty"`
+	SpeciesReport   string  `yaml:"species_report,omitempty"`
+	SpeciesDistance *int    `yaml:"species_distance,omitempty"`
+	Duplicates      string  `yaml:"duplicates,omitempty"`
+	MinGenes        int     `yaml:"min_genes,omitempty"`
+	MinCompleteness float64 `yaml:"min_completeness,omitempty"`
+	MinTaxa         int     `yaml:"min_taxa,omitempty"`
+
+	Outputs []Output `yaml:"outputs"`
+}
+
+// Read a pipeline; anything it doesn't know about is an error, so typos
+// aren't silently ignored
+func Read(r io.Reader) (Pipeline, error) {
+	var pipeline Pipeline
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return pipeline, err
+	}
+	if err = yaml.UnmarshalStrict(data, &pipeline); err != nil {
+		return pipeline, fmt.Errorf("Bad pipeline: %s", err.Error())
+	}
+	if len(pipeline.Outputs) == 0 {
+		return pipeline, fmt.Errorf("Bad pipeline: it has no outputs")
+	}
+	for i, output := range pipeline.Outputs {
+		if output.Format == "" {
+			return pipeline, fmt.Errorf("Bad pipeline: output %d has no format", i+1)
+		}
+	}
+	return pipeline, nil
+}
+
+// Write the pipeline out as YAML
+func (p Pipeline) Write(w io.Writer) error {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+/*
+paths calls change on every path in the pipeline, and sets it to what is
+returned.  pathOptions are the names of the output options which are
+paths.  Blanks, and "-" or "--" for stdin and stdout, are left alone.
+*/
+func (p *Pipeline) paths(pathOptions map[string]bool, change func(string) string) {
+	changeNonBlank := func(path string) string {
+		if path == "" || path == "-" || path == "--" {
+			return path
+		}
+		return change(path)
+	}
+	for _, path := range []*string{&p.Input, &p.RenameMap, &p.ReverseMap, &p.MergeSpecies, &p.SpeciesReport} {
+		*path = changeNonBlank(*path)
+	}
+	for i := range p.Outputs {
+		output := &p.Outputs[i]
+		output.File = changeNonBlank(output.File)
+		for name, value := range output.Options {
+			if path, ok := value.(string); ok && pathOptions[name] {
+				output.Options[name] = changeNonBlank(path)
+			}
+		}
+	}
+}
+
+// Resolve makes the relative paths in the pipeline relative to dir (the
+// directory of the pipeline file) instead
+func (p *Pipeline) Resolve(dir string, pathOptions map[string]bool) {
+	p.paths(pathOptions, func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	})
+}
+
+// Relative makes the paths in the pipeline (relative to the working
+// directory) relative to dir, where the pipeline file will be; so the
+// pipeline can be run from anywhere.  This is the opposite of Resolve.
+func (p *Pipeline) Relative(dir string, pathOptions map[string]bool) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	p.paths(pathOptions, func(path string) string {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return path
+		}
+		if relative, err := filepath.Rel(absDir, absPath); err == nil {
+			return relative
+		}
+		return absPath
+	})
+	return nil
+}
+
+// OptionNames are the names of the output's options, sorted so the
+// arguments are always in the same order
+func (o Output) OptionNames() []string {
+	names := make([]string, 0, len(o.Options))
+	for name := range o.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,120 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yarbelk/refasta/pipeline"
+)
+
+var pathOptions = map[string]bool{"ccode-file": true}
+
+func examplePipeline() pipeline.Pipeline {
+	distance := 0
+	return pipeline.Pipeline{
+		Input:           "genes",
+		InputFormat:     "fasta",
+		HeaderSchema:    "ncbi",
+		SpeciesDistance: &distance,
+		MinTaxa:         4,
+		Outputs: []pipeline.Output{{
+			Format: "tnt",
+			File:   "out/matrix.tnt",
+			Options: map[string]interface{}{
+				"outgroup":   []interface{}{"Pan troglodytes", "Homo erectus"},
+				"tnt-title":  "primates",
+				"ccode-file": "codes.txt",
+			},
+		}},
+	}
+}
+
+func TestPipelineRoundTrip(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := examplePipeline().Write(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	run, err := pipeline.Read(&buf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(run, examplePipeline()) {
+		t.Errorf("Expected the pipeline back unchanged, got %#v", run)
+	}
+	if names := run.Outputs[0].OptionNames(); !reflect.DeepEqual(names, []string{"ccode-file", "outgroup", "tnt-title"}) {
+		t.Errorf("Expected the option names sorted, got %v", names)
+	}
+}
+
+func TestReadBadPipelines(t *testing.T) {
+	for _, input := range []string{
+		"input: genes\n",
+		"input: genes\noutputs:\n- file: out.tnt\n",
+		"input: genes\nmin_taxon: 4\noutputs:\n- format: tnt\n",
+	} {
+		if _, err := pipeline.Read(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
+
+func TestResolvePaths(t *testing.T) {
+	run := examplePipeline()
+	run.RenameMap = "/data/names.csv"
+	run.Resolve("runs", pathOptions)
+
+	if run.Input != filepath.Join("runs", "genes") {
+		t.Errorf("Expected the input relative to the pipeline, got '%s'", run.Input)
+	}
+	if run.RenameMap != "/data/names.csv" {
+		t.Errorf("Expected absolute paths to be left alone, got '%s'", run.RenameMap)
+	}
+	if run.Outputs[0].File != filepath.Join("runs", "out", "matrix.tnt") {
+		t.Errorf("Expected the output relative to the pipeline, got '%s'", run.Outputs[0].File)
+	}
+	if run.Outputs[0].Options["ccode-file"] != filepath.Join("runs", "codes.txt") {
+		t.Errorf("Expected the ccode-file relative to the pipeline, got '%v'", run.Outputs[0].Options["ccode-file"])
+	}
+	if run.Outputs[0].Options["tnt-title"] != "primates" {
+		t.Errorf("Expected options that aren't paths to be left alone, got '%v'", run.Outputs[0].Options["tnt-title"])
+	}
+}
+
+func TestRelativeIsUndoneByResolve(t *testing.T) {
+	run := examplePipeline()
+	run.Input = "--"
+	if err := run.Relative("runs", pathOptions); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if run.Outputs[0].File != filepath.Join("..", "out", "matrix.tnt") {
+		t.Errorf("Expected the output relative to runs, got '%s'", run.Outputs[0].File)
+	}
+	if run.Input != "--" {
+		t.Errorf("Expected stdin to be left alone, got '%s'", run.Input)
+	}
+	run.Resolve("runs", pathOptions)
+	if filepath.Clean(run.Outputs[0].File) != filepath.Join("out", "matrix.tnt") {
+		t.Errorf("Expected the output back where it was, got '%s'", run.Outputs[0].File)
+	}
+}
+
+func TestSpeciesDistanceIsOnlySetIfGiven(t *testing.T) {
+	run, err := pipeline.Read(strings.NewReader("input: genes\noutputs:\n- format: tnt\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if run.SpeciesDistance != nil {
+		t.Errorf("Expected no species distance, got %d", *run.SpeciesDistance)
+	}
+
+	buf := bytes.Buffer{}
+	if err := run.Write(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Contains(buf.String(), "species_distance") {
+		t.Errorf("Expected the species distance to be left out, got:\n%s", buf.String())
+	}
+}
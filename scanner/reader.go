@@ -0,0 +1,70 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Reader reads runes, and keeps track of the line and column of the last
+// one read; so errors can say where the bad data is
+type Reader struct {
+	reader       *bufio.Reader
+	line, column int
+	newline      bool
+	// last is the position before the last read, for UnreadRune
+	lastLine, lastColumn int
+	lastNewline          bool
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(reader), line: 1}
+}
+
+// Position is the line and column of the last rune read; both count from
+// 1.  A new line is counted as the last character on its line.
+func (r *Reader) Position() (line, column int) {
+	return r.line, r.column
+}
+
+// ReadRune reads the next rune, and moves the position on
+func (r *Reader) ReadRune() (rune, int, error) {
+	ch, size, err := r.reader.ReadRune()
+	if err != nil {
+		return ch, size, err
+	}
+	r.lastLine, r.lastColumn, r.lastNewline = r.line, r.column, r.newline
+	if r.newline {
+		r.line++
+		r.column = 0
+	}
+	r.column++
+	r.newline = ch == '\n'
+	return ch, size, nil
+}
+
+// UnreadRune unreads the last rune, and moves the position back; it can
+// only be done once between reads
+func (r *Reader) UnreadRune() error {
+	if err := r.reader.UnreadRune(); err != nil {
+		return err
+	}
+	r.line, r.column, r.newline = r.lastLine, r.lastColumn, r.lastNewline
+	return nil
+}
+
+// ReadLine reads up to the end of the line, which is consumed but not
+// returned.  A '\r' before it is dropped as well.
+func (r *Reader) ReadLine() ([]byte, error) {
+	buf := bytes.Buffer{}
+	for {
+		ch, _, err := r.ReadRune()
+		if err != nil {
+			return buf.Bytes(), err
+		}
+		if ch == '\n' {
+			return bytes.TrimSuffix(buf.Bytes(), []byte("\r")), nil
+		}
+		buf.WriteRune(ch)
+	}
+}
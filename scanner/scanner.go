@@ -1,9 +1,9 @@
 package scanner
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"unicode"
 )
 
@@ -13,7 +13,7 @@ var eof = rune(0)
 
 // ScanWhitespace returns a contigious block of whitespaces, and return
 // literal bytes for the token, length of the string and error
-func ScanWhitespace(reader *bufio.Reader) (lit []byte, length int, err error) {
+func ScanWhitespace(reader io.RuneScanner) (lit []byte, length int, err error) {
 	buf := bytes.Buffer{}
 
 scanLoop:
@@ -24,7 +24,7 @@ scanLoop:
 			lit, err = buf.Bytes(), readErr
 			break scanLoop
 		case size > 1:
-			err = InvalidChar(fmt.Errorf("Invalid Char in stream, %s", ch))
+			err = InvalidChar(fmt.Errorf("unexpected '%c'", ch))
 			break scanLoop
 		case IsWhitespace(ch):
 			length++
@@ -53,7 +53,7 @@ func IsSequenceData(c rune) bool {
 
 // ScanSequenceData will return a string of sequence data, removing all
 // new line characters
-func ScanSequenceData(reader *bufio.Reader) (lit []byte, length int, alphabet map[rune]bool, err error) {
+func ScanSequenceData(reader io.RuneScanner) (lit []byte, length int, alphabet map[rune]bool, err error) {
 	alphabet = make(map[rune]bool)
 	buf := bytes.Buffer{}
 
@@ -65,7 +65,7 @@ scanLoop:
 			lit, err = buf.Bytes(), readErr
 			break scanLoop
 		case size > 1:
-			err = InvalidChar(fmt.Errorf("Invalid Char in stream, %s", ch))
+			err = InvalidChar(fmt.Errorf("unexpected '%c'", ch))
 			break scanLoop
 		case IsSequenceData(ch):
 			length++
@@ -80,6 +80,7 @@ scanLoop:
 			if err != nil {
 				return subSeq, subLen, alphabet, err
 			}
+			buf.WriteRune(ch)
 			buf.Write(subSeq)
 			length = length + subLen
 			continue scanLoop
@@ -94,7 +95,7 @@ scanLoop:
 			lit, err = buf.Bytes(), nil
 			break scanLoop
 		default:
-			lit, err = buf.Bytes(), InvalidChar(fmt.Errorf("No idea what this is '%s' in the char stream", string(ch)))
+			lit, err = buf.Bytes(), InvalidChar(fmt.Errorf("unexpected '%c'", ch))
 			break scanLoop
 		}
 	}
@@ -103,7 +104,7 @@ scanLoop:
 
 // scanSequenceDataGroup handles the scanning of [ATGA] like sequence
 // structures.
-func scanSequenceDataGroup(reader *bufio.Reader) (lit []byte, length int, alphabet map[rune]bool, err error) {
+func scanSequenceDataGroup(reader io.RuneScanner) (lit []byte, length int, alphabet map[rune]bool, err error) {
 	alphabet = make(map[rune]bool)
 	buf := bytes.Buffer{}
 
@@ -115,7 +116,7 @@ scanLoop:
 			lit, err = buf.Bytes(), readErr
 			break scanLoop
 		case size > 1:
-			err = InvalidChar(fmt.Errorf("Invalid Char in stream, %s", ch))
+			err = InvalidChar(fmt.Errorf("unexpected '%c'", ch))
 			break scanLoop
 		case IsWhitespace(ch):
 			continue scanLoop
@@ -129,9 +130,9 @@ scanLoop:
 			lit, err = buf.Bytes(), nil
 			break scanLoop
 		case ch == '>', ch == eof:
-			return []byte{}, 0, nil, InvalidChar(fmt.Errorf("Unbalanced [] in sequence data: postion %d", length))
+			return []byte{}, 0, nil, InvalidChar(fmt.Errorf("unbalanced [] in the sequence data"))
 		default:
-			lit, err = buf.Bytes(), InvalidChar(fmt.Errorf("No idea what this is '%s' in the char stream", ch))
+			lit, err = buf.Bytes(), InvalidChar(fmt.Errorf("unexpected '%c'", ch))
 			break scanLoop
 		}
 	}
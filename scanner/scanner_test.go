@@ -55,12 +55,31 @@ func TestStoresLengthOfSequenceWithQuestionMark(t *testing.T) {
 
 func TestStoresLengthOfSequenceWithBraces(t *testing.T) {
 	buf := bufio.NewReader(bytes.NewBuffer([]byte("AT[AG]C")))
-	_, length, _, err := scanner.ScanSequenceData(buf)
+	lit, length, _, err := scanner.ScanSequenceData(buf)
 	expected := 4
 	if err != nil {
 		t.Errorf("Expected no error: got '%s'", err.Error())
 	}
+	if string(lit) != "AT[AG]C" {
+		t.Errorf("Expected: '%s', got '%s'", "AT[AG]C", lit)
+	}
 	if length != expected {
 		t.Errorf("Expected: '%d', got '%d'", expected, length)
 	}
 }
+
+func TestReaderTracksPosition(t *testing.T) {
+	reader := scanner.NewReader(bytes.NewBufferString(">A\nAT"))
+	expected := [][2]int{{1, 1}, {1, 2}, {1, 3}, {2, 1}, {2, 2}}
+	for i, position := range expected {
+		reader.ReadRune()
+		if line, column := reader.Position(); line != position[0] || column != position[1] {
+			t.Errorf("Expected rune %d to be at %d:%d, got %d:%d", i, position[0], position[1], line, column)
+		}
+	}
+
+	reader.UnreadRune()
+	if line, column := reader.Position(); line != 2 || column != 1 {
+		t.Errorf("Expected unreading to go back to 2:1, got %d:%d", line, column)
+	}
+}
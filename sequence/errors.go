@@ -1,6 +1,10 @@
 package sequence
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 type ErrNo int
 
@@ -8,6 +12,8 @@ const (
 	UNKNOWN ErrNo = iota
 	MISSMATCHED_SEQUENCE_LENGTHS
 	BAD_FORMAT
+	UNKNOWN_SPECIES
+	DUPLICATE_SPECIES
 )
 
 // InvalidSequence is an error type that (will) hold useful data about
@@ -18,10 +24,17 @@ type InvalidSequence struct {
 	Errno   ErrNo
 }
 
+// FormatError is an error in the input.  If where it is in the file is
+// known, it is given like a compiler error; `file:line:column: message`
 type FormatError struct {
 	Message string
 	Details string
 	Errno   ErrNo
+
+	File       string
+	Line       int
+	Column     int
+	SequenceID string
 }
 
 // Error for the error interface
@@ -29,6 +42,33 @@ func (e InvalidSequence) Error() string {
 	return fmt.Sprintf("InvalidSequence: %s\nDetails: %s", e.Message, e.Details)
 }
 
+// Position is the `file:line:column` of the error; with any parts which
+// aren't known left out
+func (e FormatError) Position() string {
+	parts := []string{}
+	if e.File != "" {
+		parts = append(parts, e.File)
+	}
+	if e.Line > 0 {
+		parts = append(parts, strconv.Itoa(e.Line))
+	}
+	if e.Line > 0 && e.Column > 0 {
+		parts = append(parts, strconv.Itoa(e.Column))
+	}
+	return strings.Join(parts, ":")
+}
+
 func (e FormatError) Error() string {
-	return fmt.Sprintf("FormatError: %s\nDetails: %s", e.Message, e.Details)
+	position := e.Position()
+	if position == "" {
+		return fmt.Sprintf("FormatError: %s\nDetails: %s", e.Message, e.Details)
+	}
+	message := fmt.Sprintf("%s: %s", position, e.Message)
+	if e.SequenceID != "" {
+		message = fmt.Sprintf("%s in sequence >%s", message, e.SequenceID)
+	}
+	if e.Details != "" {
+		message = fmt.Sprintf("%s\nDetails: %s", message, e.Details)
+	}
+	return message
 }
@@ -0,0 +1,43 @@
+package sequence
+
+import "bytes"
+
+// iupacCodes is indexed by a bit mask of the bases; A=1, C=2, G=4, T=8
+const iupacCodes = "?ACMGRSVTWYHKDBN"
+
+// iupacMask returns the bit mask of the bases that an IUPAC nucleotide code
+// stands for; zero if it isn't one
+func iupacMask(c byte) byte {
+	if c == 'U' || c == 'u' {
+		c = 'T'
+	}
+	i := bytes.IndexByte([]byte(iupacCodes), bytes.ToUpper([]byte{c})[0])
+	if i < 1 {
+		return 0
+	}
+	return byte(i)
+}
+
+// IUPAC returns the IUPAC nucleotide code that covers all of the bases;
+// eg: A and G is R.  Gaps and missing data are ignored.  If there is
+// nothing we recognise, '?' is returned
+func IUPAC(bases []byte) byte {
+	var mask byte
+	for _, c := range bases {
+		mask = mask | iupacMask(c)
+	}
+	return iupacCodes[mask]
+}
+
+// IUPACBases returns the bases that an IUPAC nucleotide code stands for;
+// eg: R is AG.  It is empty if the code isn't a nucleotide code.
+func IUPACBases(code byte) []byte {
+	mask := iupacMask(code)
+	bases := []byte{}
+	for i, base := range []byte("ACGT") {
+		if mask&(1<<uint(i)) != 0 {
+			bases = append(bases, base)
+		}
+	}
+	return bases
+}
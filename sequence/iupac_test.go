@@ -0,0 +1,34 @@
+package sequence_test
+
+import (
+	"testing"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func TestIUPACCombinesBases(t *testing.T) {
+	cases := map[string]byte{
+		"A":    'A',
+		"AG":   'R',
+		"ct":   'Y',
+		"AGT":  'D',
+		"RY":   'N',
+		"A-":   'A',
+		"?":    '?',
+		"ACGU": 'N',
+	}
+	for bases, expected := range cases {
+		if got := sequence.IUPAC([]byte(bases)); got != expected {
+			t.Errorf("Expected IUPAC code for '%s' to be '%c', got '%c'", bases, expected, got)
+		}
+	}
+}
+
+func TestIUPACBasesExpandsCodes(t *testing.T) {
+	if got := string(sequence.IUPACBases('R')); got != "AG" {
+		t.Errorf("Expected R to be 'AG', got '%s'", got)
+	}
+	if got := string(sequence.IUPACBases('X')); got != "" {
+		t.Errorf("Expected X to have no bases, got '%s'", got)
+	}
+}
@@ -7,9 +7,9 @@ import "sort"
 // it is a really small structure, and we can implement a bunch of methods on it
 // so we don't have to worry about the memory footprint of giant byte arrays.
 type GeneMetaData struct {
-	Gene          string
-	Length        int
-	NumberSpecies int
+	Gene          string `json:"gene"`
+	Length        int    `json:"length"`
+	NumberSpecies int    `json:"taxa"`
 }
 
 // GMDSlice is a GeneMetaData slice, which implements the sort.Interface
@@ -0,0 +1,81 @@
+package sequence
+
+import (
+	"sort"
+	"strings"
+)
+
+// EditDistance is the Levenshtein distance between two strings; the number
+// of single character insertions, deletions or substitutions needed to
+// change one into the other
+func EditDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// closeMatch is a name, and how far it is from what we were looking for
+type closeMatch struct {
+	name     string
+	distance int
+}
+
+type byDistance []closeMatch
+
+func (m byDistance) Len() int      { return len(m) }
+func (m byDistance) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byDistance) Less(i, j int) bool {
+	if m[i].distance == m[j].distance {
+		return m[i].name < m[j].name
+	}
+	return m[i].distance < m[j].distance
+}
+
+/*
+CloseMatches returns the names which are close to name, closest first.
+Names are compared ignoring case, and with spaces and underscores treated
+as the same; so 'homo_sapien' is close to 'Homo sapiens'.  Close is an edit
+distance of up to a third of the length of the name.
+*/
+func CloseMatches(name string, names []string) []string {
+	normal := strings.ToLower(Safe(name))
+	limit := len(normal)/3 + 1
+	matches := make(byDistance, 0)
+	for _, n := range names {
+		distance := EditDistance(normal, strings.ToLower(Safe(n)))
+		if distance <= limit {
+			matches = append(matches, closeMatch{name: n, distance: distance})
+		}
+	}
+	sort.Sort(matches)
+	closest := make([]string, len(matches))
+	for i, m := range matches {
+		closest[i] = m.name
+	}
+	return closest
+}
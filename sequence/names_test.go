@@ -0,0 +1,36 @@
+package sequence_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"Homo sapiens", "Homo sapiens", 0},
+		{"Homo sapiens", "Homo sapien", 1},
+		{"Homo sapiens", "Homo spaiens", 2},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+	}
+	for _, c := range cases {
+		if got := sequence.EditDistance(c.a, c.b); got != c.expected {
+			t.Errorf("Expected the distance from '%s' to '%s' to be %d, got %d", c.a, c.b, c.expected, got)
+		}
+	}
+}
+
+func TestCloseMatchesIgnoresCaseAndUnderscores(t *testing.T) {
+	names := []string{"Homo erectus", "Homo sapiens", "Homo sapiens neanderthalensis", "Homo sapien"}
+	expected := []string{"Homo sapien", "Homo sapiens"}
+	got := sequence.CloseMatches("homo_sapien", names)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
@@ -20,7 +20,10 @@ type Sequence struct {
 	Species string
 	// Gene name
 	Gene string
-	Seq  SequenceData
+	// Fields are any other data about the sequence, taken from its
+	// header; eg the voucher or accession
+	Fields map[string]string
+	Seq    SequenceData
 	// Length is the Logical Length of a sequence; where polymorphics are counted
 	// as a single length eg: [AG] == len 1
 	Length int
@@ -39,7 +42,8 @@ func Safe(in string) string {
 type SequenceData []byte
 
 // SequenceType is one of used in TNT (and probably other things)
-// to determine if the sequence is a DNA sequence or protein sequence
+// to determine if the sequence is a DNA sequence, protein sequence or
+// morphological characters
 type SequenceType int
 
 const (
@@ -47,11 +51,33 @@ const (
 	DNA_TYPE
 	PROTEIN_TYPE
 	BLANK_TYPE
+	// MORPHOLOGY_TYPE is the states 0 to 9, with polymorphisms and
+	// missing data
+	MORPHOLOGY_TYPE
 )
 
+// String is the lower case name of the type, eg dna
+func (t SequenceType) String() string {
+	switch t {
+	case DNA_TYPE:
+		return "dna"
+	case PROTEIN_TYPE:
+		return "protein"
+	case BLANK_TYPE:
+		return "blank"
+	case MORPHOLOGY_TYPE:
+		return "morphology"
+	default:
+		return "unsupported"
+	}
+}
+
 const (
 	PROTEIN_ALPHABET = "GALMFWKQESPVICYHRNDT"
 	DNA_ALPHABET     = "ACGTWSMKRY"
+	// DNA_AMBIGUITY are the other IUPAC codes which are valid in DNA; with U
+	// for RNA.  B and U aren't amino acids, so they mark a sequence as DNA
+	DNA_AMBIGUITY = "BDHVNU"
 )
 
 // NewSequence returns a value type Sequence, this will scan the sequence data
@@ -67,6 +93,25 @@ func (s SequenceData) String() string {
 	return string(s)
 }
 
+// Characters splits the data into its logical characters; where a
+// polymorphism such as [AG] counts as a single character
+func (s SequenceData) Characters() []SequenceData {
+	chars := make([]SequenceData, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '[' {
+			end := bytes.IndexByte(s[i:], ']')
+			if end == -1 {
+				return append(chars, s[i:])
+			}
+			chars = append(chars, s[i:i+end+1])
+			i = i + end
+			continue
+		}
+		chars = append(chars, s[i:i+1])
+	}
+	return chars
+}
+
 // SafeName will replace spaces with underscores (possibly other things in
 // the future as I find the need
 func (s Sequence) SafeName() string {
@@ -82,7 +127,7 @@ func (s Sequence) SafeSpecies() string {
 func (s Sequence) GoString() string {
 	var truncatedSequence []byte
 	if len(s.Seq) > 5 {
-		truncatedSequence = append(s.Seq[:5], []byte("...")...)
+		truncatedSequence = append(append(truncatedSequence, s.Seq[:5]...), []byte("...")...)
 	} else {
 		truncatedSequence = s.Seq[:]
 	}
@@ -115,7 +160,7 @@ var isProtein charLookup = func() charLookup {
 
 var isDNA charLookup = func() charLookup {
 	var k map[rune]bool = make(map[rune]bool)
-	for _, c := range DNA_ALPHABET {
+	for _, c := range DNA_ALPHABET + DNA_AMBIGUITY {
 		k[c] = true
 	}
 	return func(c rune) bool {
@@ -123,23 +168,33 @@ var isDNA charLookup = func() charLookup {
 	}
 }()
 
-// Type of sequence, DNA, Protein, or Unsupported
+// Type of sequence, DNA, Protein, Morphology, or Unsupported
 func (s *Sequence) Type() SequenceType {
 	var protein, dna int
 	var notDNA, notProtein, blank bool = false, false, true
+	var morphology bool
 
 	for c, _ := range s.alphabet {
 		switch {
-		case c == '-':
+		case c == '-', c == '?':
+		case c >= '0' && c <= '9':
+			blank = false
+			morphology = true
 		case isProtein(c) && isDNA(c):
 			blank = false
 			protein++
 			dna++
+		case isDNA(c):
+			blank = false
+			dna++
+			notProtein = true
 		case !isProtein(c):
 			blank = false
+			notDNA = true
 			notProtein = true
 		case !isDNA(c):
 			blank = false
+			protein++
 			notDNA = true
 		default:
 			blank = false
@@ -149,22 +204,17 @@ func (s *Sequence) Type() SequenceType {
 	if blank {
 		return BLANK_TYPE
 	}
-	if !notDNA && !notProtein {
-		if dna < len(DNA_ALPHABET) {
-			fmt.Fprintf(os.Stderr, "%s only has %d Nucleic Acids, please check your data set\n", s.GoString())
-		}
-		return DNA_TYPE
+	if morphology && dna == 0 && protein == 0 && !notProtein {
+		return MORPHOLOGY_TYPE
 	}
-	if notDNA && !notProtein {
-		if dna < len(DNA_ALPHABET) {
-			fmt.Fprintf(os.Stderr, "%s only has %d Nucleic Acids, please check your data set\n", s.GoString())
-		}
+	if morphology {
+		fmt.Fprintf(os.Stderr, "Couldn't determine sequence type, %s\n", s.GoString())
+		return UNSUPPORTED_TYPE
+	}
+	if !notDNA {
 		return DNA_TYPE
 	}
-	if !notDNA && notProtein {
-		if protein < len(PROTEIN_ALPHABET) {
-			fmt.Fprintf(os.Stderr, "%s only has %d Amino Acids, please check your data set\n", s.GoString())
-		}
+	if notDNA && !notProtein {
 		return PROTEIN_TYPE
 	}
 	fmt.Fprintf(os.Stderr, "Couldn't determine sequence type, %s\n", s.GoString())
@@ -50,6 +50,16 @@ func TestIdentifiesDNAFromAlphabet(t *testing.T) {
 	}
 }
 
+func TestIdentifiesRNAAndDNAAmbiguityAsDNA(t *testing.T) {
+	for _, data := range []string{"ACGU", "ATAGBT"} {
+		seq := sequence.NewSequence("test", []byte(data))
+
+		if seq.Type() != sequence.DNA_TYPE {
+			t.Errorf("Expected %s to be '%s', was '%s'", data, sequence.DNA_TYPE, seq.Type())
+		}
+	}
+}
+
 func TestIdentifiesProteinFromAlphabet(t *testing.T) {
 	seq := sequence.NewSequence("test", []byte("SSSGSKIADT"))
 
@@ -59,3 +69,31 @@ func TestIdentifiesProteinFromAlphabet(t *testing.T) {
 		t.Errorf("Expected the sequence type to be '%s', was '%s'", expected, seq.Type())
 	}
 }
+
+func TestCharactersKeepsPolymorphismsTogether(t *testing.T) {
+	seq := sequence.SequenceData("AT[AG]C")
+	chars := seq.Characters()
+	expected := []string{"A", "T", "[AG]", "C"}
+	if len(chars) != len(expected) {
+		t.Fatalf("Expected %d characters, got %d: %q", len(expected), len(chars), chars)
+	}
+	for i, c := range chars {
+		if string(c) != expected[i] {
+			t.Errorf("Expected character %d to be '%s', got '%s'", i, expected[i], c)
+		}
+	}
+}
+
+func TestMorphologyType(t *testing.T) {
+	seq := sequence.NewSequence("Homo sapiens", []byte("0120[01]?-9"))
+	if seq.Type() != sequence.MORPHOLOGY_TYPE {
+		t.Errorf("Expected MORPHOLOGY_TYPE (%d), got %d", sequence.MORPHOLOGY_TYPE, seq.Type())
+	}
+}
+
+func TestDigitsAndLettersAreUnsupported(t *testing.T) {
+	seq := sequence.NewSequence("Homo sapiens", []byte("ACGT0120"))
+	if seq.Type() != sequence.UNSUPPORTED_TYPE {
+		t.Errorf("Expected UNSUPPORTED_TYPE (%d), got %d", sequence.UNSUPPORTED_TYPE, seq.Type())
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Package taxa finds, and fixes, species names which are meant to be the
+same but aren't; `Homo sapiens`, `Homo  sapiens` and `Homo_sapien` would
+otherwise be three rows in the matrix.
+*/
+package taxa
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+// MAX_DISTANCE is the default largest edit distance between two names
+// for them to be counted as the same species
+const MAX_DISTANCE = 2
+
+/*
+Normalise tidies up a species name; underscores are read as spaces, runs
+of white space become one space, and the name is trimmed.  The genus is
+capitalised, and the rest is left alone.
+*/
+func Normalise(name string) string {
+	name = strings.Join(strings.Fields(strings.Replace(name, "_", " ", -1)), " ")
+	if name == "" {
+		return name
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// key is what names are compared on; normalised, and ignoring case
+func key(name string) string {
+	return strings.ToLower(Normalise(name))
+}
+
+// Cluster is a set of names that look like they are the same species
+type Cluster struct {
+	// Canonical is the name they should all be called; the normalised
+	// form of the name with the most sequences
+	Canonical string
+	// Names in the input, and how many sequences had each one
+	Names  []string
+	Counts map[string]int
+}
+
+// Mapping returns the names that need to change, and what they change to
+func (c Cluster) Mapping() Mapping {
+	mapping := make(Mapping)
+	for _, name := range c.Names {
+		if name != c.Canonical {
+			mapping[name] = c.Canonical
+		}
+	}
+	return mapping
+}
+
+// similar is true if the names are near enough to be the same species.  The
+// distance allowed is smaller for short names, so that `A a` and `B b`
+// aren't the same.
+func similar(a, b string, maxDistance int) bool {
+	if a == b {
+		return true
+	}
+	limit := len(a) / 5
+	if len(b) < len(a) {
+		limit = len(b) / 5
+	}
+	if limit > maxDistance {
+		limit = maxDistance
+	}
+	return sequence.EditDistance(a, b) <= limit
+}
+
+/*
+Reconcile groups the species of the sequences into clusters of names
+that are within maxDistance edits of each other (once normalised, and
+ignoring case).  Only clusters with more than one name, or where the name
+isn't already normalised, are returned; sorted by canonical name.
+*/
+func Reconcile(seqs []sequence.Sequence, maxDistance int) []Cluster {
+	counts := make(map[string]int)
+	names := []string{}
+	for _, seq := range seqs {
+		if _, ok := counts[seq.Species]; !ok {
+			names = append(names, seq.Species)
+		}
+		counts[seq.Species]++
+	}
+	sort.Strings(names)
+
+	// Single linkage clustering; parent is a union-find forest
+	parent := make([]int, len(names))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = key(name)
+	}
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			if similar(keys[i], keys[j], maxDistance) {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]string)
+	for i, name := range names {
+		groups[root(i)] = append(groups[root(i)], name)
+	}
+	clusters := []Cluster{}
+	for _, group := range groups {
+		if len(group) == 1 && Normalise(group[0]) == group[0] {
+			continue
+		}
+		cluster := Cluster{Names: group, Counts: make(map[string]int)}
+		best := ""
+		for _, name := range group {
+			cluster.Counts[name] = counts[name]
+			if best == "" || counts[name] > counts[best] {
+				best = name
+			}
+		}
+		cluster.Canonical = Normalise(best)
+		clusters = append(clusters, cluster)
+	}
+	sort.Sort(byCanonical(clusters))
+	return clusters
+}
+
+type byCanonical []Cluster
+
+func (c byCanonical) Len() int           { return len(c) }
+func (c byCanonical) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byCanonical) Less(i, j int) bool { return c[i].Canonical < c[j].Canonical }
+
+/*
+WriteReport writes out the suspected duplicates as a mapping file; each
+name that would change, a tab, and what it would change to.  Once it has
+been checked (and any wrong lines deleted), it can be read back with
+ReadMapping to merge them.
+
+	# Homo sapiens: Homo  sapiens (1), Homo sapiens (3), Homo_sapien (1)
+	Homo  sapiens	Homo sapiens
+	Homo_sapien	Homo sapiens
+*/
+func WriteReport(writer io.Writer, clusters []Cluster) error {
+	if _, err := fmt.Fprintf(writer, "# Suspected duplicate species; the name in the input, a tab, and the name to merge it into.\n"+
+		"# Check these, and delete any lines that are wrong, before using this to merge them.\n"); err != nil {
+		return err
+	}
+	for _, cluster := range clusters {
+		counts := make([]string, len(cluster.Names))
+		for i, name := range cluster.Names {
+			counts[i] = fmt.Sprintf("%s (%d)", name, cluster.Counts[name])
+		}
+		if _, err := fmt.Fprintf(writer, "# %s: %s\n", cluster.Canonical, strings.Join(counts, ", ")); err != nil {
+			return err
+		}
+		mapping := cluster.Mapping()
+		for _, name := range cluster.Names {
+			if to, ok := mapping[name]; ok {
+				if _, err := fmt.Fprintf(writer, "%s\t%s\n", name, to); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Mapping of species names in the input, to what they should be
+type Mapping map[string]string
+
+/*
+ReadMapping reads a tab separated mapping file, such as the one written by
+WriteReport.  Blank lines, and lines starting with a `#`, are skipped.
+*/
+func ReadMapping(input io.Reader) (Mapping, error) {
+	mapping := make(Mapping)
+	lines := bufio.NewScanner(input)
+	for number := 1; lines.Scan(); number++ {
+		line := strings.TrimRight(lines.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		columns := strings.Split(line, "\t")
+		if len(columns) != 2 || columns[0] == "" || strings.TrimSpace(columns[1]) == "" {
+			return nil, sequence.FormatError{
+				Message: "Badly formated species mapping",
+				Details: fmt.Sprintf("line %d: expected 'from<tab>to', got '%s'", number, line),
+				Errno:   sequence.BAD_FORMAT,
+			}
+		}
+		mapping[columns[0]] = strings.TrimSpace(columns[1])
+	}
+	return mapping, lines.Err()
+}
+
+// Merge renames the species of the sequences using the mapping, and
+// returns how many were renamed
+func (m Mapping) Merge(seqs []sequence.Sequence) int {
+	merged := 0
+	for i, _ := range seqs {
+		if to, ok := m[seqs[i].Species]; ok {
+			seqs[i].Species = to
+			merged++
+		}
+	}
+	return merged
+}
@@ -0,0 +1,80 @@
+package taxa_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/yarbelk/refasta/sequence"
+	"github.com/yarbelk/refasta/taxa"
+)
+
+func speciesSequences(species ...string) []sequence.Sequence {
+	seqs := make([]sequence.Sequence, len(species))
+	for i, name := range species {
+		seqs[i] = sequence.NewSequence(name, []byte("ATAG"))
+		seqs[i].Species = name
+	}
+	return seqs
+}
+
+func TestNormalise(t *testing.T) {
+	cases := map[string]string{
+		"Homo sapiens":      "Homo sapiens",
+		"  Homo   sapiens ": "Homo sapiens",
+		"Homo_sapiens":      "Homo sapiens",
+		"homo sapiens":      "Homo sapiens",
+	}
+	for in, expected := range cases {
+		if got := taxa.Normalise(in); got != expected {
+			t.Errorf("Expected '%s' to normalise to '%s', got '%s'", in, expected, got)
+		}
+	}
+}
+
+func TestReconcileClustersSimilarNames(t *testing.T) {
+	seqs := speciesSequences("Homo sapiens", "Homo sapiens", "Homo  sapiens", "Homo_sapien", "Homo erectus", "A a", "B b")
+	clusters := taxa.Reconcile(seqs, taxa.MAX_DISTANCE)
+
+	if len(clusters) != 1 {
+		t.Fatalf("Expected one cluster, got %d: %v", len(clusters), clusters)
+	}
+	if clusters[0].Canonical != "Homo sapiens" {
+		t.Errorf("Expected the canonical name to be 'Homo sapiens', got '%s'", clusters[0].Canonical)
+	}
+	expected := taxa.Mapping{"Homo  sapiens": "Homo sapiens", "Homo_sapien": "Homo sapiens"}
+	if got := clusters[0].Mapping(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the mapping %v, got %v", expected, got)
+	}
+}
+
+func TestReportReadsBackAsMapping(t *testing.T) {
+	seqs := speciesSequences("Homo sapiens", "Homo sapiens", "Homo_sapien")
+	buf := bytes.Buffer{}
+	if err := taxa.WriteReport(&buf, taxa.Reconcile(seqs, taxa.MAX_DISTANCE)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	mapping, err := taxa.ReadMapping(&buf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if merged := mapping.Merge(seqs); merged != 1 {
+		t.Errorf("Expected 1 sequence to be merged, got %d", merged)
+	}
+	for _, seq := range seqs {
+		if seq.Species != "Homo sapiens" {
+			t.Errorf("Expected all the species to be 'Homo sapiens', got '%s'", seq.Species)
+		}
+	}
+}
+
+func TestReadMappingBadLine(t *testing.T) {
+	_, err := taxa.ReadMapping(bytes.NewBufferString("# comment\nHomo sapien Homo sapiens\n"))
+	if err == nil {
+		t.Fatalf("Expected an error, got <nil>")
+	}
+	if err.(sequence.FormatError).Errno != sequence.BAD_FORMAT {
+		t.Errorf("Expected a BAD_FORMAT error, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package taxa
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/yarbelk/refasta/sequence"
+)
+
+/*
+RenameMap is a table of names (eg lab voucher codes) and the names they
+should be changed to (eg the accepted species name).  Names are matched
+with spaces and underscores treated as the same, as that is how they come
+back out of TNT and PHYLIP.
+*/
+type RenameMap struct {
+	// Comma separates the columns; ',' for CSV, and '\t' for TSV
+	Comma rune
+	// from is the names in the order they are in the file
+	from []string
+	// to is keyed by the safe name
+	to map[string]string
+	// used is the safe names that matched a sequence, and renamed is what
+	// the sequences were called before, keyed by the new name
+	used    map[string]bool
+	renamed map[string][]string
+}
+
+/*
+ReadRenameMap reads the table, with the columns separated by comma.  The
+first column is the name in the input, and the second is the new name;
+any other columns are ignored.  Lines starting with a `#` are skipped; so
+a header row needs to be commented out.
+
+	# voucher,species
+	USNM123,Homo sapiens
+	USNM124,Homo sapiens
+*/
+func ReadRenameMap(input io.Reader, comma rune) (*RenameMap, error) {
+	reader := csv.NewReader(input)
+	reader.Comma = comma
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	r := &RenameMap{
+		Comma:   comma,
+		to:      make(map[string]string),
+		used:    make(map[string]bool),
+		renamed: make(map[string][]string),
+	}
+	for entry := 1; ; entry++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return r, nil
+		}
+		if err != nil {
+			return nil, sequence.FormatError{
+				Message: "Badly formated rename map",
+				Details: err.Error(),
+				Errno:   sequence.BAD_FORMAT,
+			}
+		}
+		if len(record) < 2 || strings.TrimSpace(record[0]) == "" || strings.TrimSpace(record[1]) == "" {
+			return nil, sequence.FormatError{
+				Message: "Badly formated rename map",
+				Details: fmt.Sprintf("entry %d: expected the name and the new name, got '%s'", entry, strings.Join(record, string(comma))),
+				Errno:   sequence.BAD_FORMAT,
+			}
+		}
+		from := strings.TrimSpace(record[0])
+		if _, ok := r.to[sequence.Safe(from)]; !ok {
+			r.from = append(r.from, from)
+		}
+		r.to[sequence.Safe(from)] = strings.TrimSpace(record[1])
+	}
+}
+
+/*
+Rename changes the Name and Species of the sequences that are in the map.
+A sequence is matched on its Name, then the Fields read from its header
+(so a voucher can be matched), and then its Species.  The names (or
+species, if they have one) of the sequences that weren't in the map are
+returned, without duplicates.
+*/
+func (r *RenameMap) Rename(seqs []sequence.Sequence) []string {
+	unmapped := []string{}
+	seen := make(map[string]bool)
+	for i, _ := range seqs {
+		seq := &seqs[i]
+		matched := ""
+		for _, name := range candidates(seq) {
+			if _, ok := r.to[sequence.Safe(name)]; ok && name != "" {
+				matched = name
+				break
+			}
+		}
+		if matched == "" {
+			name := seq.Species
+			if name == "" {
+				name = seq.Name
+			}
+			if !seen[name] {
+				seen[name] = true
+				unmapped = append(unmapped, name)
+			}
+			continue
+		}
+		to := r.to[sequence.Safe(matched)]
+		r.used[sequence.Safe(matched)] = true
+		r.renamed[to] = insertString(r.renamed[to], matched)
+		seq.Name = to
+		seq.Species = to
+	}
+	sort.Strings(unmapped)
+	return unmapped
+}
+
+// candidates are the names a sequence can be matched on, in order
+func candidates(seq *sequence.Sequence) []string {
+	keys := make([]string, 0, len(seq.Fields))
+	for key, _ := range seq.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	names := []string{seq.Name}
+	for _, key := range keys {
+		names = append(names, seq.Fields[key])
+	}
+	return append(names, seq.Species)
+}
+
+// insertString adds s to the sorted slice, if it isn't already there
+func insertString(slice []string, s string) []string {
+	i := sort.SearchStrings(slice, s)
+	if i < len(slice) && slice[i] == s {
+		return slice
+	}
+	return append(slice[:i], append([]string{s}, slice[i:]...)...)
+}
+
+// Unused returns the names in the map that didn't match any sequence, in
+// the order they are in the file
+func (r *RenameMap) Unused() []string {
+	unused := []string{}
+	for _, from := range r.from {
+		if !r.used[sequence.Safe(from)] {
+			unused = append(unused, from)
+		}
+	}
+	return unused
+}
+
+/*
+WriteReverse writes out the map the other way around; the new names, and
+the names they were renamed from.  Reading this back in with the output
+of TNT or PHYLIP will restore the original names.  If more than one name
+was renamed to the same new name, they are all written out; but only the
+last one is used when it is read back in.
+*/
+func (r *RenameMap) WriteReverse(writer io.Writer) error {
+	to := make([]string, 0, len(r.renamed))
+	for name, _ := range r.renamed {
+		to = append(to, name)
+	}
+	sort.Strings(to)
+
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Comma = r.Comma
+	for _, name := range to {
+		for _, from := range r.renamed[name] {
+			if err := csvWriter.Write([]string{name, from}); err != nil {
+				return err
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
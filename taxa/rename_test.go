@@ -0,0 +1,79 @@
+package taxa_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/yarbelk/refasta/sequence"
+	"github.com/yarbelk/refasta/taxa"
+)
+
+func TestRenameMap(t *testing.T) {
+	input := "# voucher,species\nUSNM123,Homo sapiens\n\"USNM 124\",Homo sapiens\nUSNM999,Pan troglodytes\n"
+	renames, err := taxa.ReadRenameMap(bytes.NewBufferString(input), ',')
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	seqs := speciesSequences("USNM123", "USNM_124", "Gorilla gorilla")
+	seqs[2].Fields = map[string]string{"voucher": "USNM123"}
+
+	unmapped := renames.Rename(seqs)
+	for _, seq := range seqs[:2] {
+		if seq.Name != "Homo sapiens" || seq.Species != "Homo sapiens" {
+			t.Errorf("Expected 'Homo sapiens', got name '%s' and species '%s'", seq.Name, seq.Species)
+		}
+	}
+	if seqs[2].Species != "Homo sapiens" {
+		t.Errorf("Expected the voucher field to be matched, got '%s'", seqs[2].Species)
+	}
+	if len(unmapped) != 0 {
+		t.Errorf("Expected no unmapped names, got %v", unmapped)
+	}
+	if unused := renames.Unused(); !reflect.DeepEqual(unused, []string{"USNM999"}) {
+		t.Errorf("Expected [USNM999] to be unused, got %v", unused)
+	}
+}
+
+func TestRenameReportsUnmapped(t *testing.T) {
+	renames, _ := taxa.ReadRenameMap(bytes.NewBufferString("A1\tA a\n"), '\t')
+	unmapped := renames.Rename(speciesSequences("B b", "A1", "B b"))
+	if !reflect.DeepEqual(unmapped, []string{"B b"}) {
+		t.Errorf("Expected [B b] to be unmapped, got %v", unmapped)
+	}
+}
+
+func TestReverseMapRestoresNames(t *testing.T) {
+	renames, _ := taxa.ReadRenameMap(bytes.NewBufferString("USNM123\tHomo sapiens\n"), '\t')
+	seqs := speciesSequences("USNM123")
+	renames.Rename(seqs)
+
+	buf := bytes.Buffer{}
+	if err := renames.WriteReverse(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if buf.String() != "Homo sapiens\tUSNM123\n" {
+		t.Errorf("Expected the reverse map 'Homo sapiens\\tUSNM123', got '%s'", buf.String())
+	}
+
+	reverse, err := taxa.ReadRenameMap(&buf, '\t')
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// As read back from TNT or PHYLIP
+	seqs = speciesSequences("Homo_sapiens")
+	reverse.Rename(seqs)
+	if seqs[0].Species != "USNM123" {
+		t.Errorf("Expected the name to be restored to 'USNM123', got '%s'", seqs[0].Species)
+	}
+}
+
+func TestReadRenameMapBadRow(t *testing.T) {
+	_, err := taxa.ReadRenameMap(bytes.NewBufferString("A1,A a\nB1\n"), ',')
+	if err == nil {
+		t.Fatalf("Expected an error, got <nil>")
+	}
+	if err.(sequence.FormatError).Errno != sequence.BAD_FORMAT {
+		t.Errorf("Expected a BAD_FORMAT error, got %v", err)
+	}
+}